@@ -0,0 +1,259 @@
+package replicache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrAdminUnsupported is returned by the admin API when the store does not
+// implement AdminStore.
+var ErrAdminUnsupported = errors.New("store does not support admin operations")
+
+// WithAdminAuth sets the AuthFn guarding AdminHandler. It is separate from
+// WithAuth, so client tokens don't grant admin access. Without it every
+// admin request is rejected.
+func WithAdminAuth(fn AuthFn) Option {
+	return func(o *Options) {
+		o.adminAuthFn = fn
+	}
+}
+
+// AdminHandler returns the admin API, which serves:
+//
+//	GET    /spaces
+//	GET    /spaces/{spaceID}/entries?prefix=
+//	GET    /spaces/{spaceID}/entries/{key...}
+//	PUT    /spaces/{spaceID}/entries/{key...}
+//	DELETE /spaces/{spaceID}/entries/{key...}
+//	POST   /spaces/{spaceID}/reset
+//	GET    /clients
+//	DELETE /clients/{clientID}
+//	POST   /clients/{clientID}/revoke
+//	DELETE /clients/{clientID}/revoke
+//
+// Mount it under a prefix with http.StripPrefix. Entries are written through
+// Transact, so the space version is bumped and its clients are poked.
+// Reading or deleting a missing entry is answered with 404 Not Found.
+// Resetting a space makes every client pull a full snapshot, and deleting a
+// client resets it: its state is forgotten, so it starts over on its next
+// request. Revoking a client rejects its requests, see RevokeClient.
+func (r *Replicache[T]) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /spaces", r.adminListSpaces)
+	mux.HandleFunc("GET /spaces/{spaceID}/entries", r.adminListEntries)
+	mux.HandleFunc("GET /spaces/{spaceID}/entries/{key...}", r.adminGetEntry)
+	mux.HandleFunc("PUT /spaces/{spaceID}/entries/{key...}", r.adminPutEntry)
+	mux.HandleFunc("DELETE /spaces/{spaceID}/entries/{key...}", r.adminDelEntry)
+	mux.HandleFunc("POST /spaces/{spaceID}/reset", r.adminResetSpace)
+	mux.HandleFunc("GET /clients", r.adminListClients)
+	mux.HandleFunc("DELETE /clients/{clientID}", r.adminDeleteClient)
+	mux.HandleFunc("POST /clients/{clientID}/revoke", r.adminRevokeClient)
+	mux.HandleFunc("DELETE /clients/{clientID}/revoke", r.adminRestoreClient)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if r.options.adminAuthFn == nil {
+			writeError(ctx, w, http.StatusUnauthorized, nil)
+			return
+		}
+
+		principal, ok := r.options.adminAuthFn(ctx, req.Header.Get(authorizationHeader))
+		if !ok {
+			writeError(ctx, w, http.StatusUnauthorized, nil)
+			return
+		}
+		if principal != nil {
+			req = req.WithContext(ContextWithPrincipal(ctx, principal))
+		}
+
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func (r *Replicache[T]) adminStore(ctx context.Context, w http.ResponseWriter) (AdminStore, bool) {
+	if r.store == nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, ErrNoStore)
+		return nil, false
+	}
+	store, ok := r.store.(AdminStore)
+	if !ok {
+		r.writeServerError(ctx, w, http.StatusNotImplemented, ErrAdminUnsupported)
+		return nil, false
+	}
+	return store, true
+}
+
+func (r *Replicache[T]) adminListSpaces(w http.ResponseWriter, req *http.Request) {
+	store, ok := r.adminStore(req.Context(), w)
+	if !ok {
+		return
+	}
+	spaces, err := store.ListSpaces()
+	r.writeJSON(req.Context(), w, spaces, err)
+}
+
+func (r *Replicache[T]) adminListClients(w http.ResponseWriter, req *http.Request) {
+	store, ok := r.adminStore(req.Context(), w)
+	if !ok {
+		return
+	}
+	clients, err := store.ListClients()
+	r.writeJSON(req.Context(), w, clients, err)
+}
+
+type adminEntry[T any] struct {
+	Key     string `json:"key"`
+	Value   T      `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+func (r *Replicache[T]) adminListEntries(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if r.store == nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, ErrNoStore)
+		return
+	}
+
+	prefix := req.URL.Query().Get("prefix")
+	live, err := r.store.GetEntries(req.PathValue(SpaceIDParam), prefix)
+	if err != nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Entries are in key order from prefix, so those with it come first.
+	entries := make([]adminEntry[T], 0)
+	for _, e := range live {
+		if !strings.HasPrefix(e.Key, prefix) {
+			break
+		}
+		entries = append(entries, adminEntry[T]{Key: e.Key, Value: e.Value, Version: e.Version})
+	}
+	r.writeJSON(ctx, w, entries, nil)
+}
+
+func (r *Replicache[T]) adminGetEntry(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if r.store == nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, ErrNoStore)
+		return
+	}
+
+	value, err := r.store.GetEntry(req.PathValue(SpaceIDParam), req.PathValue("key"))
+	if errors.Is(err, ErrNotFound) {
+		writeError(ctx, w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	r.writeJSON(ctx, w, value, nil)
+}
+
+func (r *Replicache[T]) adminPutEntry(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	value := new(T)
+	if err := json.NewDecoder(req.Body).Decode(value); err != nil {
+		writeError(ctx, w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := r.Transact(ctx, req.PathValue(SpaceIDParam), func(tx ReadWriteTransaction[T]) error {
+		return tx.Put(req.PathValue("key"), value)
+	})
+	if errors.Is(err, ErrQuotaExceeded) {
+		r.writeServerError(ctx, w, http.StatusInsufficientStorage, err)
+		return
+	}
+	if err != nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Replicache[T]) adminDelEntry(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	// A missing entry is reported rather than deleted, so the space version
+	// isn't bumped for nothing.
+	err := r.Transact(ctx, req.PathValue(SpaceIDParam), func(tx ReadWriteTransaction[T]) error {
+		if _, err := tx.Get(req.PathValue("key")); err != nil {
+			return err
+		}
+		return tx.Del(req.PathValue("key"))
+	})
+	if errors.Is(err, ErrNotFound) {
+		writeError(ctx, w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Replicache[T]) adminResetSpace(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	store, ok := r.adminStore(ctx, w)
+	if !ok {
+		return
+	}
+
+	spaceID := req.PathValue(SpaceIDParam)
+	r.mu.Lock()
+	version, err := r.strategy.NextVersion(r.store, spaceID)
+	if err == nil {
+		err = store.ResetSpace(spaceID, version)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	r.options.poker.Poke(spaceID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Replicache[T]) adminDeleteClient(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	store, ok := r.adminStore(ctx, w)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	err := store.DeleteClient(req.PathValue("clientID"))
+	r.mu.Unlock()
+	if err != nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Replicache[T]) adminRevokeClient(w http.ResponseWriter, req *http.Request) {
+	r.RevokeClient(req.PathValue("clientID"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Replicache[T]) adminRestoreClient(w http.ResponseWriter, req *http.Request) {
+	r.RestoreClient(req.PathValue("clientID"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Replicache[T]) writeJSON(ctx context.Context, w http.ResponseWriter, v any, err error) {
+	if err != nil {
+		r.writeServerError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", applicationJSON)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,111 @@
+package replicache_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/airheartdev/replicache"
+)
+
+func (s *SyncSuite) admin(method string, path string, body string) *httptest.ResponseRecorder {
+	handler := http.StripPrefix("/admin", s.rep.AdminHandler())
+
+	req := httptest.NewRequest(method, "/admin"+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "admin-token")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func (s *SyncSuite) TestAdminRequiresAuth() {
+	w := s.admin(http.MethodGet, "/spaces", "")
+	s.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (s *SyncSuite) TestAdmin() {
+	broker := replicache.NewPokeBroker()
+	s.setup(
+		replicache.WithPoker(broker),
+		replicache.WithAdminAuth(func(ctx context.Context, token string) (any, bool) {
+			return "admin", token == "admin-token"
+		}),
+	)
+
+	pokes, cancel := broker.Subscribe("space-1")
+	defer cancel()
+
+	w := s.admin(http.MethodPut, "/spaces/space-1/entries/todo/1", `"one"`)
+	s.Require().Equal(http.StatusNoContent, w.Code)
+	s.Len(pokes, 1)
+
+	resp := s.pull(replicache.DefaultPullEndpoint+"/space-1", 0)
+	s.Equal(uint64(1), resp.Cookie)
+	s.Require().Len(resp.Patch, 2)
+	s.Equal("todo/1", *resp.Patch[1].Key)
+
+	w = s.admin(http.MethodGet, "/spaces/space-1/entries/todo/1", "")
+	s.Equal(http.StatusOK, w.Code)
+	s.JSONEq(`"one"`, w.Body.String())
+
+	w = s.admin(http.MethodGet, "/spaces/space-1/entries/todo/9", "")
+	s.Equal(http.StatusNotFound, w.Code)
+
+	w = s.admin(http.MethodGet, "/spaces/space-1/entries?prefix=todo/", "")
+	s.JSONEq(`[{"key":"todo/1","value":"one","version":1}]`, w.Body.String())
+
+	w = s.admin(http.MethodGet, "/spaces", "")
+	s.JSONEq(`[{"id":"space-1","version":1}]`, w.Body.String())
+
+	w = s.admin(http.MethodDelete, "/spaces/space-1/entries/todo/1", "")
+	s.Require().Equal(http.StatusNoContent, w.Code)
+	resp = s.pull(replicache.DefaultPullEndpoint+"/space-1", 1)
+	s.Equal(uint64(2), resp.Cookie)
+	s.Require().Len(resp.Patch, 1)
+	s.Equal(replicache.PatchDel, resp.Patch[0].Op)
+
+	// Deleting a missing entry doesn't bump the version.
+	w = s.admin(http.MethodDelete, "/spaces/space-1/entries/todo/9", "")
+	s.Require().Equal(http.StatusNotFound, w.Code)
+	resp = s.pull(replicache.DefaultPullEndpoint+"/space-1", 2)
+	s.Equal(uint64(2), resp.Cookie)
+	s.Empty(resp.Patch)
+
+	// Clients at the current cookie must reset.
+	w = s.admin(http.MethodPost, "/spaces/space-1/reset", "")
+	s.Require().Equal(http.StatusNoContent, w.Code)
+	resp = s.pull(replicache.DefaultPullEndpoint+"/space-1", 2)
+	s.Equal(uint64(3), resp.Cookie)
+	s.Require().Len(resp.Patch, 1)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "missing"})
+	w = s.admin(http.MethodGet, "/clients", "")
+	var clients []replicache.ClientInfo
+	s.Require().NoError(json.NewDecoder(w.Body).Decode(&clients))
+	s.Require().Len(clients, 1)
+	s.Equal("client-1", clients[0].ID)
+
+	w = s.admin(http.MethodDelete, "/clients/client-1", "")
+	s.Require().Equal(http.StatusNoContent, w.Code)
+	w = s.post(replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "client-1", Cookie: 3, LastMutationID: 1})
+	s.JSONEq(`{"error":"ClientStateNotFound"}`, w.Body.String())
+
+	// A revoked client is turned away until it is restored.
+	w = s.admin(http.MethodPost, "/clients/client-1/revoke", "")
+	s.Require().Equal(http.StatusNoContent, w.Code)
+	w = s.post(replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "client-1"})
+	s.Equal(http.StatusForbidden, w.Code)
+	w = s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{ClientID: "client-1"})
+	s.Equal(http.StatusForbidden, w.Code)
+	w = s.post(replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "client-2"})
+	s.Equal(http.StatusOK, w.Code)
+
+	w = s.admin(http.MethodDelete, "/clients/client-1/revoke", "")
+	s.Require().Equal(http.StatusNoContent, w.Code)
+	w = s.post(replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "client-1"})
+	s.Equal(http.StatusOK, w.Code)
+}
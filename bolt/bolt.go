@@ -0,0 +1,567 @@
+// Package bolt implements a durable Replicache store on an embedded bbolt
+// database.
+//
+// Each space has its own bucket holding its entries, its version and an
+// index of entry keys ordered by the version they last changed at, so pulls
+// only visit changed entries. Bucket names can't be empty, so the default
+// space, with an empty ID, has a bucket of its own outside the others.
+// Clients are stored in a shared bucket.
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var ErrNotFound = replicache.ErrNotFound
+
+var (
+	spacesBucket       = []byte("spaces")
+	defaultSpaceBucket = []byte("defaultSpace")
+	clientsBucket      = []byte("clients")
+	entriesBucket      = []byte("entries")
+	changesBucket      = []byte("changes")
+	versionKey         = []byte("version")
+	minCookieKey       = []byte("minCookie")
+)
+
+type (
+	BoltBackend[T any] struct {
+		db    *bbolt.DB
+		codec replicache.Codec[T]
+	}
+
+	Option func(o *options)
+
+	options struct {
+		codec    any
+		timeout  time.Duration
+		readOnly bool
+	}
+
+	Space struct {
+		ID      string `json:"id"`
+		Version uint64 `json:"version"`
+	}
+
+	Client struct {
+		ID             string    `json:"id"`
+		LastMutationID uint64    `json:"lastMutationID"`
+		LastModifiedAt time.Time `json:"lastModifiedAt"`
+	}
+
+	record struct {
+		Value          []byte    `json:"value,omitempty"`
+		Deleted        bool      `json:"deleted,omitempty"`
+		Version        uint64    `json:"version"`
+		LastModifiedAt time.Time `json:"lastModifiedAt"`
+	}
+)
+
+var (
+	_ replicache.Store[any]  = &BoltBackend[any]{}
+	_ replicache.CookieFloor = &BoltBackend[any]{}
+	_ replicache.AdminStore  = &BoltBackend[any]{}
+)
+
+// WithCodec sets the Codec used to encode values. It must be a Codec for the
+// value type of the backend. Defaults to replicache.JSONCodec.
+func WithCodec[T any](codec replicache.Codec[T]) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithTimeout sets how long Open waits for the file lock held by another
+// process. Defaults to one second.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithReadOnly opens an existing database for reading only, sharing its
+// file lock with other readers. Writes fail.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// Open opens or creates the database at path.
+func Open[T any](path string, opts ...Option) (*BoltBackend[T], error) {
+	o := options{
+		codec:   replicache.JSONCodec[T]{},
+		timeout: time.Second,
+	}
+	for _, option := range opts {
+		option(&o)
+	}
+
+	codec, ok := o.codec.(replicache.Codec[T])
+	if !ok {
+		return nil, fmt.Errorf("codec %T does not encode %T", o.codec, *new(T))
+	}
+
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: o.timeout, ReadOnly: o.readOnly})
+	if err != nil {
+		return nil, err
+	}
+	if o.readOnly {
+		err := db.View(func(tx *bbolt.Tx) error {
+			if tx.Bucket(spacesBucket) == nil || tx.Bucket(clientsBucket) == nil {
+				return fmt.Errorf("%s is not a replicache database", path)
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &BoltBackend[T]{db: db, codec: codec}, nil
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(spacesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(clientsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltBackend[T]{db: db, codec: codec}, nil
+}
+
+func (b *BoltBackend[T]) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltBackend[T]) GetEntry(spaceID string, key string) (*T, error) {
+	var value *T
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		space := getSpace(tx, spaceID)
+		if space == nil {
+			return ErrNotFound
+		}
+
+		rec, err := getRecord(space, key)
+		if err != nil {
+			return err
+		}
+		if rec == nil || rec.Deleted {
+			return ErrNotFound
+		}
+
+		v, err := b.codec.Unmarshal(rec.Value)
+		if err != nil {
+			return err
+		}
+		value = &v
+		return nil
+	})
+	return value, err
+}
+
+func (b *BoltBackend[T]) PutEntry(spaceID string, key string, value T, version uint64) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		space, err := createSpace(tx, spaceID)
+		if err != nil {
+			return err
+		}
+		return b.putEntry(space, replicache.Entry[T]{
+			Key:            key,
+			Value:          value,
+			Version:        version,
+			LastModifiedAt: time.Now(),
+		})
+	})
+}
+
+func (b *BoltBackend[T]) DelEntry(spaceID string, key string, version uint64) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		space := getSpace(tx, spaceID)
+		if space == nil {
+			return ErrNotFound
+		}
+		if rec, err := getRecord(space, key); err != nil {
+			return err
+		} else if rec == nil {
+			return ErrNotFound
+		}
+
+		return b.putEntry(space, replicache.Entry[T]{
+			Key:            key,
+			Deleted:        true,
+			Version:        version,
+			LastModifiedAt: time.Now(),
+		})
+	})
+}
+
+// GetEntries returns the live entries of spaceID from fromKey onwards, in key
+// order.
+func (b *BoltBackend[T]) GetEntries(spaceID string, fromKey string) ([]*replicache.Entry[T], error) {
+	entries := make([]*replicache.Entry[T], 0)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		space := getSpace(tx, spaceID)
+		if space == nil {
+			return nil
+		}
+
+		c := space.Bucket(entriesBucket).Cursor()
+		for k, v := c.Seek([]byte(fromKey)); k != nil; k, v = c.Next() {
+			entry, err := b.decodeEntry(spaceID, k, v)
+			if err != nil {
+				return err
+			}
+			if !entry.Deleted {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (b *BoltBackend[T]) GetCookie(spaceID string) (uint64, error) {
+	var version uint64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if space := getSpace(tx, spaceID); space != nil {
+			version = getVersion(space, versionKey)
+		}
+		return nil
+	})
+	return version, err
+}
+
+func (b *BoltBackend[T]) GetLastMutationID(clientID string) (uint64, bool, error) {
+	client, err := b.getClient(clientID)
+	if err != nil || client == nil {
+		return 0, false, err
+	}
+	return client.LastMutationID, true, nil
+}
+
+// GetSpaces returns every space in ID order.
+func (b *BoltBackend[T]) GetSpaces() ([]Space, error) {
+	spaces := make([]Space, 0)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if space := tx.Bucket(defaultSpaceBucket); space != nil {
+			spaces = append(spaces, Space{Version: getVersion(space, versionKey)})
+		}
+		return tx.Bucket(spacesBucket).ForEach(func(k, v []byte) error {
+			spaces = append(spaces, Space{
+				ID:      string(k),
+				Version: getVersion(tx.Bucket(spacesBucket).Bucket(k), versionKey),
+			})
+			return nil
+		})
+	})
+	return spaces, err
+}
+
+// GetClients returns every client in ID order.
+func (b *BoltBackend[T]) GetClients() ([]Client, error) {
+	clients := make([]Client, 0)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(clientsBucket).ForEach(func(k, v []byte) error {
+			client := Client{}
+			if err := json.Unmarshal(v, &client); err != nil {
+				return err
+			}
+			clients = append(clients, client)
+			return nil
+		})
+	})
+	return clients, err
+}
+
+func (b *BoltBackend[T]) getClient(clientID string) (*Client, error) {
+	var client *Client
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(clientsBucket).Get([]byte(clientID))
+		if v == nil {
+			return nil
+		}
+		client = &Client{}
+		return json.Unmarshal(v, client)
+	})
+	return client, err
+}
+
+// GetChangedEntries walks the change index of spaceID from prevVersion, so
+// its cost is proportional to the number of changes rather than the size of
+// the space. Entries are returned in version order.
+func (b *BoltBackend[T]) GetChangedEntries(spaceID string, prevVersion uint64) ([]*replicache.Entry[T], error) {
+	entries := make([]*replicache.Entry[T], 0)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		space := getSpace(tx, spaceID)
+		if space == nil {
+			return nil
+		}
+
+		values := space.Bucket(entriesBucket)
+		c := space.Bucket(changesBucket).Cursor()
+		for k, _ := c.Seek(versionBytes(prevVersion + 1)); k != nil; k, _ = c.Next() {
+			key := k[8:]
+			entry, err := b.decodeEntry(spaceID, key, values.Get(key))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Transaction returns a transaction which buffers writes in memory until
+// they are committed.
+func (b *BoltBackend[T]) Transaction(spaceID string, clientID string, version uint64) replicache.ReadWriteTransaction[T] {
+	return memory.ReplicacheTransaction[T](b, spaceID, clientID, version)
+}
+
+// Commit writes cs in a single bolt transaction.
+func (b *BoltBackend[T]) Commit(cs replicache.ChangeSet[T]) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		space, err := createSpace(tx, cs.SpaceID)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range cs.Entries {
+			if entry.Deleted {
+				if rec, err := getRecord(space, entry.Key); err != nil {
+					return err
+				} else if rec == nil {
+					continue
+				}
+			}
+			if err := b.putEntry(space, entry); err != nil {
+				return err
+			}
+		}
+
+		if err := space.Put(versionKey, versionBytes(cs.Version)); err != nil {
+			return err
+		}
+
+		if cs.ClientID == "" {
+			return nil
+		}
+
+		client, err := json.Marshal(Client{
+			ID:             cs.ClientID,
+			LastMutationID: cs.LastMutationID,
+			LastModifiedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(clientsBucket).Put([]byte(cs.ClientID), client)
+	})
+}
+
+// GetMinCookie returns the version a space was last reset at.
+func (b *BoltBackend[T]) GetMinCookie(spaceID string) (uint64, error) {
+	var minCookie uint64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		if space := getSpace(tx, spaceID); space != nil {
+			minCookie = getVersion(space, minCookieKey)
+		}
+		return nil
+	})
+	return minCookie, err
+}
+
+// ListSpaces implements replicache.AdminStore.
+func (b *BoltBackend[T]) ListSpaces() ([]replicache.SpaceInfo, error) {
+	spaces, err := b.GetSpaces()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]replicache.SpaceInfo, 0, len(spaces))
+	for _, space := range spaces {
+		minCookie, err := b.GetMinCookie(space.ID)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, replicache.SpaceInfo{
+			ID:        space.ID,
+			Version:   space.Version,
+			MinCookie: minCookie,
+		})
+	}
+	return infos, nil
+}
+
+// ListClients implements replicache.AdminStore.
+func (b *BoltBackend[T]) ListClients() ([]replicache.ClientInfo, error) {
+	clients, err := b.GetClients()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]replicache.ClientInfo, 0, len(clients))
+	for _, client := range clients {
+		infos = append(infos, replicache.ClientInfo(client))
+	}
+	return infos, nil
+}
+
+// ResetSpace implements replicache.AdminStore.
+func (b *BoltBackend[T]) ResetSpace(spaceID string, version uint64) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		space, err := createSpace(tx, spaceID)
+		if err != nil {
+			return err
+		}
+		if err := space.Put(versionKey, versionBytes(version)); err != nil {
+			return err
+		}
+		if err := space.Put(minCookieKey, versionBytes(version)); err != nil {
+			return err
+		}
+		return deleteViews(tx, func(viewSpaceID, _ string) bool { return viewSpaceID == spaceID })
+	})
+}
+
+// DeleteClient implements replicache.AdminStore.
+func (b *BoltBackend[T]) DeleteClient(clientID string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(clientsBucket).Delete([]byte(clientID)); err != nil {
+			return err
+		}
+		return deleteViews(tx, func(_, clientGroupID string) bool { return clientGroupID == clientID })
+	})
+}
+
+// putEntry writes entry and moves it to its new version in the change
+// index.
+func (b *BoltBackend[T]) putEntry(space *bbolt.Bucket, entry replicache.Entry[T]) error {
+	key := []byte(entry.Key)
+	values := space.Bucket(entriesBucket)
+	changes := space.Bucket(changesBucket)
+
+	prev, err := getRecord(space, entry.Key)
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		if err := changes.Delete(changeKey(prev.Version, key)); err != nil {
+			return err
+		}
+	}
+
+	rec := record{
+		Deleted:        entry.Deleted,
+		Version:        entry.Version,
+		LastModifiedAt: entry.LastModifiedAt,
+	}
+	if !entry.Deleted {
+		rec.Value, err = b.codec.Marshal(entry.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := values.Put(key, data); err != nil {
+		return err
+	}
+	return changes.Put(changeKey(entry.Version, key), nil)
+}
+
+func (b *BoltBackend[T]) decodeEntry(spaceID string, key []byte, data []byte) (*replicache.Entry[T], error) {
+	rec := record{}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+
+	entry := &replicache.Entry[T]{
+		SpaceID:        spaceID,
+		Key:            string(key),
+		Deleted:        rec.Deleted,
+		Version:        rec.Version,
+		LastModifiedAt: rec.LastModifiedAt,
+	}
+	if !rec.Deleted {
+		value, err := b.codec.Unmarshal(rec.Value)
+		if err != nil {
+			return nil, err
+		}
+		entry.Value = value
+	}
+	return entry, nil
+}
+
+func getSpace(tx *bbolt.Tx, spaceID string) *bbolt.Bucket {
+	if spaceID == "" {
+		return tx.Bucket(defaultSpaceBucket)
+	}
+	return tx.Bucket(spacesBucket).Bucket([]byte(spaceID))
+}
+
+func createSpace(tx *bbolt.Tx, spaceID string) (*bbolt.Bucket, error) {
+	var space *bbolt.Bucket
+	var err error
+	if spaceID == "" {
+		space, err = tx.CreateBucketIfNotExists(defaultSpaceBucket)
+	} else {
+		space, err = tx.Bucket(spacesBucket).CreateBucketIfNotExists([]byte(spaceID))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := space.CreateBucketIfNotExists(entriesBucket); err != nil {
+		return nil, err
+	}
+	if _, err := space.CreateBucketIfNotExists(changesBucket); err != nil {
+		return nil, err
+	}
+	return space, nil
+}
+
+func getRecord(space *bbolt.Bucket, key string) (*record, error) {
+	data := space.Bucket(entriesBucket).Get([]byte(key))
+	if data == nil {
+		return nil, nil
+	}
+	rec := &record{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// getVersion returns the version stored at key in space, or 0.
+func getVersion(space *bbolt.Bucket, key []byte) uint64 {
+	if v := space.Get(key); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func versionBytes(version uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, version)
+	return b
+}
+
+// changeKey orders the change index by version, then key.
+func changeKey(version uint64, key []byte) []byte {
+	return bytes.Join([][]byte{versionBytes(version), key}, nil)
+}
@@ -0,0 +1,283 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltBackend(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "replicache.db")
+
+	backend, err := Open[string](path)
+	require.NoError(t, err)
+
+	tx := backend.Transaction("Space1", "client-1", 1)
+	v1, v2 := "one", "two"
+	a.NoError(tx.Put("todo/1", &v1))
+	a.NoError(tx.Put("todo/2", &v2))
+	a.NoError(backend.Commit(replicache.ChangeSet[string]{
+		SpaceID:        "Space1",
+		ClientID:       "client-1",
+		Version:        1,
+		LastMutationID: 2,
+		Entries:        tx.Changes(),
+	}))
+
+	tx = backend.Transaction("Space1", "client-1", 2)
+	_, err = tx.Get("todo/1")
+	a.NoError(err)
+	a.NoError(tx.Del("todo/1"))
+	a.NoError(backend.Commit(replicache.ChangeSet[string]{
+		SpaceID:        "Space1",
+		ClientID:       "client-1",
+		Version:        2,
+		LastMutationID: 3,
+		Entries:        tx.Changes(),
+	}))
+	a.NoError(backend.Close())
+
+	backend, err = Open[string](path, WithReadOnly())
+	require.NoError(t, err)
+	defer backend.Close()
+
+	spaces, err := backend.GetSpaces()
+	a.NoError(err)
+	a.Equal([]Space{{ID: "Space1", Version: 2}}, spaces)
+
+	cookie, err := backend.GetCookie("Space1")
+	a.NoError(err)
+	a.Equal(uint64(2), cookie)
+
+	lastMutationID, ok, err := backend.GetLastMutationID("client-1")
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(uint64(3), lastMutationID)
+
+	_, err = backend.GetEntry("Space1", "todo/1")
+	a.ErrorIs(err, ErrNotFound)
+	two, err := backend.GetEntry("Space1", "todo/2")
+	a.NoError(err)
+	a.Equal("two", *two)
+
+	changes, err := backend.GetChangedEntries("Space1", 0)
+	a.NoError(err)
+	a.Len(changes, 2)
+	a.Equal("todo/2", changes[0].Key)
+	a.Equal("todo/1", changes[1].Key)
+	a.True(changes[1].Deleted)
+
+	changes, err = backend.GetChangedEntries("Space1", 1)
+	a.NoError(err)
+	a.Len(changes, 1)
+	a.Equal("todo/1", changes[0].Key)
+
+	entries, err := backend.GetEntries("Space1", "")
+	a.NoError(err)
+	a.Len(entries, 1)
+
+	changes, err = backend.GetChangedEntries("Space2", 0)
+	a.NoError(err)
+	a.Empty(changes)
+	cookie, err = backend.GetCookie("Space2")
+	a.NoError(err)
+	a.Zero(cookie)
+
+	// Reads fail once the database is closed.
+	a.NoError(backend.Close())
+	_, err = backend.GetCookie("Space1")
+	a.Error(err)
+	_, err = backend.GetChangedEntries("Space1", 0)
+	a.Error(err)
+}
+
+func TestBoltDefaultSpace(t *testing.T) {
+	a := assert.New(t)
+
+	backend, err := Open[string](filepath.Join(t.TempDir(), "replicache.db"))
+	require.NoError(t, err)
+	defer backend.Close()
+
+	// The default space has an empty ID, which can't name a bucket.
+	one := "one"
+	a.NoError(backend.Commit(replicache.ChangeSet[string]{
+		Version: 1,
+		Entries: []replicache.Entry[string]{{Key: "todo/1", Value: one, Version: 1}},
+	}))
+	a.NoError(backend.PutEntry("Space1", "todo/1", "uno", 1))
+
+	cookie, err := backend.GetCookie("")
+	a.NoError(err)
+	a.Equal(uint64(1), cookie)
+
+	changes, err := backend.GetChangedEntries("", 0)
+	a.NoError(err)
+	a.Len(changes, 1)
+	a.Equal("one", changes[0].Value)
+
+	spaces, err := backend.GetSpaces()
+	a.NoError(err)
+	a.Equal([]Space{{ID: "", Version: 1}, {ID: "Space1"}}, spaces)
+}
+
+func TestBoltAdmin(t *testing.T) {
+	a := assert.New(t)
+
+	backend, err := Open[string](filepath.Join(t.TempDir(), "replicache.db"))
+	require.NoError(t, err)
+	defer backend.Close()
+
+	a.NoError(backend.Commit(replicache.ChangeSet[string]{SpaceID: "Space1", ClientID: "client-1", Version: 1, LastMutationID: 1}))
+	a.NoError(backend.ResetSpace("Space1", 2))
+	minCookie, err := backend.GetMinCookie("Space1")
+	a.NoError(err)
+	a.Equal(uint64(2), minCookie)
+
+	spaces, err := backend.ListSpaces()
+	a.NoError(err)
+	a.Equal([]replicache.SpaceInfo{{ID: "Space1", Version: 2, MinCookie: 2}}, spaces)
+
+	clients, err := backend.ListClients()
+	a.NoError(err)
+	a.Len(clients, 1)
+
+	a.NoError(backend.DeleteClient("client-1"))
+	_, ok, err := backend.GetLastMutationID("client-1")
+	a.NoError(err)
+	a.False(ok)
+}
+
+func TestBoltClientViews(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "replicache.db")
+
+	backend, err := Open[string](path)
+	require.NoError(t, err)
+
+	for cookie := uint64(1); cookie <= 3; cookie++ {
+		a.NoError(backend.PutClientView("space-1", "client-1", replicache.ClientView{
+			Cookie: cookie,
+			Rows:   map[string]uint64{"todo/1": cookie},
+		}))
+	}
+
+	view, ok, err := backend.GetClientView("space-1", "client-1", 2)
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(map[string]uint64{"todo/1": 2}, view.Rows)
+
+	_, ok, err = backend.GetClientView("space-1", "client-2", 2)
+	a.NoError(err)
+	a.False(ok)
+
+	a.NoError(backend.PruneClientViews("space-1", "client-1", 3))
+	_, ok, _ = backend.GetClientView("space-1", "client-1", 2)
+	a.False(ok)
+	require.NoError(t, backend.Close())
+
+	backend, err = Open[string](path)
+	require.NoError(t, err)
+	defer backend.Close()
+
+	latest, err := backend.GetLatestCookie("space-1", "client-1")
+	a.NoError(err)
+	a.Equal(uint64(3), latest)
+	_, ok, _ = backend.GetClientView("space-1", "client-1", 3)
+	a.True(ok)
+
+	latest, err = backend.GetLatestCookie("space-2", "client-1")
+	a.NoError(err)
+	a.Zero(latest)
+}
+
+func TestBoltDeleteClientViews(t *testing.T) {
+	a := assert.New(t)
+
+	backend, err := Open[string](filepath.Join(t.TempDir(), "replicache.db"))
+	require.NoError(t, err)
+	defer backend.Close()
+
+	for _, spaceID := range []string{"space-1", "space-2"} {
+		for _, clientID := range []string{"client-1", "client-2"} {
+			a.NoError(backend.PutClientView(spaceID, clientID, replicache.ClientView{Cookie: 1}))
+		}
+	}
+
+	// A deleted client loses its views in every space.
+	a.NoError(backend.DeleteClient("client-1"))
+	_, ok, _ := backend.GetClientView("space-1", "client-1", 1)
+	a.False(ok)
+	_, ok, _ = backend.GetClientView("space-2", "client-1", 1)
+	a.False(ok)
+
+	// A reset space loses the views of every client.
+	a.NoError(backend.ResetSpace("space-1", 5))
+	_, ok, _ = backend.GetClientView("space-1", "client-2", 1)
+	a.False(ok)
+	_, ok, _ = backend.GetClientView("space-2", "client-2", 1)
+	a.True(ok)
+}
+
+func TestBoltClientViewsKeys(t *testing.T) {
+	a := assert.New(t)
+
+	backend, err := Open[string](filepath.Join(t.TempDir(), "replicache.db"))
+	require.NoError(t, err)
+	defer backend.Close()
+
+	// Both pairs joined with a NUL byte would share "a\x00b\x00c".
+	a.NoError(backend.PutClientView("a\x00b", "c", replicache.ClientView{Cookie: 1}))
+	a.NoError(backend.PutClientView("a", "b\x00c", replicache.ClientView{Cookie: 2}))
+	a.NoError(backend.PutClientView("", "", replicache.ClientView{Cookie: 3}))
+
+	latest, err := backend.GetLatestCookie("a\x00b", "c")
+	a.NoError(err)
+	a.Equal(uint64(1), latest)
+
+	// Resetting one space leaves the views of the other.
+	a.NoError(backend.ResetSpace("a", 5))
+	_, ok, _ := backend.GetClientView("a", "b\x00c", 2)
+	a.False(ok)
+	_, ok, _ = backend.GetClientView("a\x00b", "c", 1)
+	a.True(ok)
+	_, ok, _ = backend.GetClientView("", "", 3)
+	a.True(ok)
+}
+
+func TestBoltExpireClientViews(t *testing.T) {
+	a := assert.New(t)
+
+	backend, err := Open[string](filepath.Join(t.TempDir(), "replicache.db"))
+	require.NoError(t, err)
+	defer backend.Close()
+
+	a.NoError(backend.PutClientView("space-1", "client-1", replicache.ClientView{Cookie: 1}))
+	a.NoError(backend.PutClientView("space-2", "client-1", replicache.ClientView{Cookie: 1}))
+
+	expired, err := backend.ExpireClientViews(time.Hour)
+	a.NoError(err)
+	a.Zero(expired)
+	_, ok, _ := backend.GetClientView("space-1", "client-1", 1)
+	a.True(ok)
+
+	// Views unused since the cutoff are dropped.
+	expired, err = backend.ExpireClientViews(0)
+	a.NoError(err)
+	a.Equal(2, expired)
+	_, ok, _ = backend.GetClientView("space-1", "client-1", 1)
+	a.False(ok)
+	latest, err := backend.GetLatestCookie("space-2", "client-1")
+	a.NoError(err)
+	a.Zero(latest)
+
+	// A client group which pulls again is in use again.
+	a.NoError(backend.PutClientView("space-1", "client-1", replicache.ClientView{Cookie: 2}))
+	expired, err = backend.ExpireClientViews(time.Hour)
+	a.NoError(err)
+	a.Zero(expired)
+}
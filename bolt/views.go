@@ -0,0 +1,194 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// viewsBucket holds a bucket of client view records for each client group
+// of each space, keyed by cookie.
+var viewsBucket = []byte("views")
+
+// viewsUsedBucket holds when the views of each client group were last used
+// by a pull, keyed like the buckets in viewsBucket.
+var viewsUsedBucket = []byte("viewsUsed")
+
+var _ replicache.ClientViewStore = &BoltBackend[any]{}
+
+// GetClientView implements replicache.ClientViewStore.
+func (b *BoltBackend[T]) GetClientView(spaceID string, clientGroupID string, cookie uint64) (replicache.ClientView, bool, error) {
+	var view replicache.ClientView
+	var ok bool
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		views := getViews(tx, spaceID, clientGroupID)
+		if views == nil {
+			return nil
+		}
+		data := views.Get(versionBytes(cookie))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &view)
+	})
+	return view, ok, err
+}
+
+// GetLatestCookie implements replicache.ClientViewStore.
+func (b *BoltBackend[T]) GetLatestCookie(spaceID string, clientGroupID string) (uint64, error) {
+	var latest uint64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		views := getViews(tx, spaceID, clientGroupID)
+		if views == nil {
+			return nil
+		}
+		if k, _ := views.Cursor().Last(); k != nil {
+			latest = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// PutClientView implements replicache.ClientViewStore.
+func (b *BoltBackend[T]) PutClientView(spaceID string, clientGroupID string, view replicache.ClientView) error {
+	data, err := json.Marshal(view)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		all, err := tx.CreateBucketIfNotExists(viewsBucket)
+		if err != nil {
+			return err
+		}
+		views, err := all.CreateBucketIfNotExists(viewsKey(spaceID, clientGroupID))
+		if err != nil {
+			return err
+		}
+		if err := views.Put(versionBytes(view.Cookie), data); err != nil {
+			return err
+		}
+		return useViews(tx, spaceID, clientGroupID)
+	})
+}
+
+// PruneClientViews implements replicache.ClientViewStore.
+func (b *BoltBackend[T]) PruneClientViews(spaceID string, clientGroupID string, cookie uint64) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		views := getViews(tx, spaceID, clientGroupID)
+		if views == nil {
+			return nil
+		}
+		c := views.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) < cookie; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return useViews(tx, spaceID, clientGroupID)
+	})
+}
+
+// ExpireClientViews drops the views of client groups which haven't pulled
+// for longer than olderThan, and returns the number of client groups whose
+// views were dropped. A client group which pulls again is sent a full
+// snapshot. Clients which only pull are never deleted, so their views are
+// only dropped this way.
+func (b *BoltBackend[T]) ExpireClientViews(olderThan time.Duration) (int, error) {
+	cutoff := uint64(time.Now().Add(-olderThan).UnixNano())
+	var expired int
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		used := tx.Bucket(viewsUsedBucket)
+		return deleteViews(tx, func(spaceID string, clientGroupID string) bool {
+			var at []byte
+			if used != nil {
+				at = used.Get(viewsKey(spaceID, clientGroupID))
+			}
+			if at != nil && binary.BigEndian.Uint64(at) >= cutoff {
+				return false
+			}
+			expired++
+			return true
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+func getViews(tx *bbolt.Tx, spaceID string, clientGroupID string) *bbolt.Bucket {
+	all := tx.Bucket(viewsBucket)
+	if all == nil {
+		return nil
+	}
+	return all.Bucket(viewsKey(spaceID, clientGroupID))
+}
+
+// useViews records that the views of clientGroupID in spaceID were used by
+// a pull now.
+func useViews(tx *bbolt.Tx, spaceID string, clientGroupID string) error {
+	used, err := tx.CreateBucketIfNotExists(viewsUsedBucket)
+	if err != nil {
+		return err
+	}
+	return used.Put(viewsKey(spaceID, clientGroupID), versionBytes(uint64(time.Now().UnixNano())))
+}
+
+// deleteViews drops the views of every client group in every space for
+// which match returns true.
+func deleteViews(tx *bbolt.Tx, match func(spaceID string, clientGroupID string) bool) error {
+	all := tx.Bucket(viewsBucket)
+	if all == nil {
+		return nil
+	}
+
+	// Buckets can't be deleted while the cursor is over them.
+	var keys [][]byte
+	err := all.ForEach(func(k, _ []byte) error {
+		spaceID, clientGroupID, ok := parseViewsKey(k)
+		if ok && match(spaceID, clientGroupID) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	used := tx.Bucket(viewsUsedBucket)
+	for _, k := range keys {
+		if err := all.DeleteBucket(k); err != nil {
+			return err
+		}
+		if used == nil {
+			continue
+		}
+		if err := used.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// viewsKey prefixes the IDs with the length of the space ID, so any pair of
+// IDs has a key of its own, which is never empty.
+func viewsKey(spaceID string, clientGroupID string) []byte {
+	key := binary.AppendUvarint(nil, uint64(len(spaceID)))
+	key = append(key, spaceID...)
+	return append(key, clientGroupID...)
+}
+
+// parseViewsKey returns the IDs of a key made by viewsKey.
+func parseViewsKey(key []byte) (spaceID string, clientGroupID string, ok bool) {
+	n, size := binary.Uvarint(key)
+	if size <= 0 || n > uint64(len(key)-size) {
+		return "", "", false
+	}
+	key = key[size:]
+	return string(key[:n]), string(key[n:]), true
+}
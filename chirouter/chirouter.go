@@ -0,0 +1,19 @@
+// Package chirouter mounts Replicache endpoints on a chi router.
+package chirouter
+
+import (
+	"github.com/airheartdev/replicache"
+	"github.com/go-chi/chi/v5"
+)
+
+// Mount registers the push, pull and poke endpoints of rep on router.
+func Mount[T any](router chi.Router, rep *replicache.Replicache[T]) {
+	MountRoutes(router, rep.Routes())
+}
+
+// MountRoutes registers routes, e.g. from Replicache.RoutesWith, on router.
+func MountRoutes(router chi.Router, routes []replicache.Route) {
+	for _, route := range routes {
+		router.Handle(route.Path, route.Handler)
+	}
+}
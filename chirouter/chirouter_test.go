@@ -0,0 +1,63 @@
+package chirouter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMount(t *testing.T) {
+	a := assert.New(t)
+
+	var spaceID string
+	rep := replicache.New[string](replicache.WithSpaceAuthorizer(func(ctx context.Context, principal any, id string, op replicache.Operation) error {
+		spaceID = id
+		return nil
+	}))
+	rep.SetStore(memory.New[string]())
+
+	router := chi.NewRouter()
+	Mount(router, rep)
+
+	req := httptest.NewRequest(http.MethodPost, replicache.DefaultPullEndpoint+"/space-1", strings.NewReader(`{"clientID":"c1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(replicache.ReplicacheRequestIDHeader, "1")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("space-1", spaceID)
+}
+
+func TestMountRoutes(t *testing.T) {
+	a := assert.New(t)
+
+	var pushed string
+	rep := replicache.New[string]()
+	router := chi.NewRouter()
+	MountRoutes(router, rep.RoutesWith(
+		func(ctx context.Context, pr *replicache.PushRequest, spaceID string) error {
+			pushed = spaceID
+			return nil
+		},
+		rep.Pull,
+	))
+
+	req := httptest.NewRequest(http.MethodPost, replicache.DefaultPushEndpoint+"/space-1", strings.NewReader(`{"clientID":"c1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(replicache.ReplicacheRequestIDHeader, "1")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("space-1", pushed)
+}
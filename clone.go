@@ -0,0 +1,37 @@
+package replicache
+
+// Cloner is implemented by value types which hold references, such as maps
+// or slices, so that the copies handed out by transactions and backends
+// never alias stored state. Types without references are copied by value and
+// don't need to implement it.
+type Cloner[T any] interface {
+	Clone() T
+}
+
+// Clone returns a copy of v, using its Clone method if T or *T implements
+// Cloner[T].
+func Clone[T any](v T) T {
+	if c, ok := any(v).(Cloner[T]); ok {
+		return c.Clone()
+	}
+	if c, ok := any(&v).(Cloner[T]); ok {
+		return c.Clone()
+	}
+	return v
+}
+
+// ClonePtr returns a pointer to a copy of *v, or nil.
+func ClonePtr[T any](v *T) *T {
+	if v == nil {
+		return nil
+	}
+	c := Clone(*v)
+	return &c
+}
+
+// Clone returns a copy of the entry and its value.
+func (e *Entry[T]) Clone() *Entry[T] {
+	c := *e
+	c.Value = Clone(e.Value)
+	return &c
+}
@@ -0,0 +1,296 @@
+// Command replicache-inspect prints the contents of a Replicache store.
+//
+// Usage:
+//
+//	replicache-inspect [-format table|json] <store> spaces
+//	replicache-inspect [-format table|json] <store> clients
+//	replicache-inspect [-format table|json] <store> entries <spaceID> [prefix]
+//	replicache-inspect [-format table|json] <store> changes <spaceID> <fromCookie> [toCookie]
+//
+// changes lists the entries changed after fromCookie, up to and including
+// toCookie when given.
+//
+// The store is a bolt database file, or a directory (or its snapshot.json)
+// written by memory.Open. Stores are opened read-only, and values are printed
+// as the JSON they were stored as.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/bolt"
+	"github.com/airheartdev/replicache/memory"
+)
+
+type (
+	store interface {
+		spaces() ([]space, error)
+		clients() ([]client, error)
+		entries(spaceID string, prefix string) ([]*replicache.Entry[json.RawMessage], error)
+		changes(spaceID string, fromCookie uint64) ([]*replicache.Entry[json.RawMessage], error)
+		Close() error
+	}
+
+	space struct {
+		ID      string `json:"id"`
+		Version uint64 `json:"version"`
+	}
+
+	client struct {
+		ID             string    `json:"id"`
+		LastMutationID uint64    `json:"lastMutationID"`
+		LastModifiedAt time.Time `json:"lastModifiedAt"`
+	}
+
+	entry struct {
+		Key            string          `json:"key"`
+		Value          json.RawMessage `json:"value,omitempty"`
+		Deleted        bool            `json:"deleted,omitempty"`
+		Version        uint64          `json:"version"`
+		LastModifiedAt time.Time       `json:"lastModifiedAt"`
+	}
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("replicache-inspect: ")
+
+	format := flag.String("format", "table", "output format: table or json")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: replicache-inspect [-format table|json] <store> spaces|clients|entries|changes [args]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 || (*format != "table" && *format != "json") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	rows, err := run(s, flag.Arg(1), flag.Args()[2:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rows)
+	} else {
+		err = writeTable(os.Stdout, rows)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(s store, command string, args []string) (any, error) {
+	switch command {
+	case "spaces":
+		return s.spaces()
+
+	case "clients":
+		return s.clients()
+
+	case "entries":
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("usage: entries <spaceID> [prefix]")
+		}
+		prefix := ""
+		if len(args) == 2 {
+			prefix = args[1]
+		}
+		entries, err := s.entries(args[0], prefix)
+		return toEntries(entries), err
+
+	case "changes":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("usage: changes <spaceID> <fromCookie> [toCookie]")
+		}
+		from, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fromCookie: %w", err)
+		}
+		to := uint64(0)
+		if len(args) == 3 {
+			if to, err = strconv.ParseUint(args[2], 10, 64); err != nil {
+				return nil, fmt.Errorf("toCookie: %w", err)
+			}
+		}
+
+		changes, err := s.changes(args[0], from)
+		if err != nil {
+			return nil, err
+		}
+		// Only the newest change to each key is kept, so a key changed after
+		// toCookie no longer shows its earlier change.
+		filtered := changes[:0]
+		for _, e := range changes {
+			if to == 0 || e.Version <= to {
+				filtered = append(filtered, e)
+			}
+		}
+		return toEntries(filtered), nil
+	}
+
+	return nil, fmt.Errorf("unknown command %q", command)
+}
+
+func open(path string) (store, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Base(path) == "snapshot.json" {
+		path, info = filepath.Dir(path), nil
+	}
+	if info == nil || info.IsDir() {
+		be, err := memory.Load[json.RawMessage](path)
+		if err != nil {
+			return nil, err
+		}
+		return memoryStore{be}, nil
+	}
+
+	be, err := bolt.Open[json.RawMessage](path, bolt.WithReadOnly())
+	if err != nil {
+		return nil, err
+	}
+	return boltStore{be}, nil
+}
+
+type memoryStore struct {
+	*memory.MemoryBackend[json.RawMessage]
+}
+
+func (s memoryStore) spaces() ([]space, error) {
+	spaces := make([]space, 0)
+	for _, sp := range s.GetSpaces() {
+		spaces = append(spaces, space{ID: sp.ID, Version: sp.Version})
+	}
+	return spaces, nil
+}
+
+func (s memoryStore) clients() ([]client, error) {
+	clients := make([]client, 0)
+	for _, c := range s.GetClients() {
+		clients = append(clients, client(c))
+	}
+	return clients, nil
+}
+
+func (s memoryStore) entries(spaceID string, prefix string) ([]*replicache.Entry[json.RawMessage], error) {
+	entries, err := s.GetEntries(spaceID, prefix)
+	return withPrefix(entries, prefix), err
+}
+
+func (s memoryStore) changes(spaceID string, fromCookie uint64) ([]*replicache.Entry[json.RawMessage], error) {
+	return s.GetChangedEntries(spaceID, fromCookie)
+}
+
+type boltStore struct {
+	*bolt.BoltBackend[json.RawMessage]
+}
+
+func (s boltStore) spaces() ([]space, error) {
+	spaces, err := s.GetSpaces()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]space, 0, len(spaces))
+	for _, sp := range spaces {
+		rows = append(rows, space(sp))
+	}
+	return rows, nil
+}
+
+func (s boltStore) clients() ([]client, error) {
+	clients, err := s.GetClients()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]client, 0, len(clients))
+	for _, c := range clients {
+		rows = append(rows, client(c))
+	}
+	return rows, nil
+}
+
+func (s boltStore) entries(spaceID string, prefix string) ([]*replicache.Entry[json.RawMessage], error) {
+	entries, err := s.GetEntries(spaceID, prefix)
+	return withPrefix(entries, prefix), err
+}
+
+func (s boltStore) changes(spaceID string, fromCookie uint64) ([]*replicache.Entry[json.RawMessage], error) {
+	return s.GetChangedEntries(spaceID, fromCookie)
+}
+
+// withPrefix trims entries, which are in key order from prefix, to those
+// starting with prefix.
+func withPrefix(entries []*replicache.Entry[json.RawMessage], prefix string) []*replicache.Entry[json.RawMessage] {
+	for i, e := range entries {
+		if !strings.HasPrefix(e.Key, prefix) {
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+func toEntries(entries []*replicache.Entry[json.RawMessage]) []entry {
+	rows := make([]entry, 0, len(entries))
+	for _, e := range entries {
+		rows = append(rows, entry{
+			Key:            e.Key,
+			Value:          e.Value,
+			Deleted:        e.Deleted,
+			Version:        e.Version,
+			LastModifiedAt: e.LastModifiedAt,
+		})
+	}
+	return rows
+}
+
+func writeTable(w io.Writer, rows any) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	switch rows := rows.(type) {
+	case []space:
+		fmt.Fprintln(tw, "SPACE\tVERSION")
+		for _, s := range rows {
+			fmt.Fprintf(tw, "%s\t%d\n", s.ID, s.Version)
+		}
+	case []client:
+		fmt.Fprintln(tw, "CLIENT\tLAST MUTATION ID\tLAST MODIFIED")
+		for _, c := range rows {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", c.ID, c.LastMutationID, c.LastModifiedAt.Format(time.RFC3339))
+		}
+	case []entry:
+		fmt.Fprintln(tw, "KEY\tVERSION\tLAST MODIFIED\tVALUE")
+		for _, e := range rows {
+			value := string(e.Value)
+			if e.Deleted {
+				value = "(deleted)"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", e.Key, e.Version, e.LastModifiedAt.Format(time.RFC3339), value)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixture writes a store with memory.Open and returns its directory.
+func fixture(t *testing.T) string {
+	dir := t.TempDir()
+	backend, err := memory.Open[json.RawMessage](dir)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, backend.Commit(replicache.ChangeSet[json.RawMessage]{
+		SpaceID: "space-1", ClientID: "client-1", Version: 1, LastMutationID: 1,
+		Entries: []replicache.Entry[json.RawMessage]{
+			{SpaceID: "space-1", Key: "todo/1", Value: json.RawMessage(`"one"`), Version: 1, LastModifiedAt: now},
+			{SpaceID: "space-1", Key: "todo/2", Value: json.RawMessage(`"two"`), Version: 1, LastModifiedAt: now},
+			{SpaceID: "space-1", Key: "list/1", Value: json.RawMessage(`"groceries"`), Version: 1, LastModifiedAt: now},
+		},
+	}))
+	// The delete is only in the write-ahead log.
+	require.NoError(t, backend.Snapshot())
+	require.NoError(t, backend.Commit(replicache.ChangeSet[json.RawMessage]{
+		SpaceID: "space-1", ClientID: "client-1", Version: 2, LastMutationID: 2,
+		Entries: []replicache.Entry[json.RawMessage]{
+			{SpaceID: "space-1", Key: "todo/1", Deleted: true, Version: 2, LastModifiedAt: now},
+		},
+	}))
+	require.NoError(t, backend.Close())
+	return dir
+}
+
+func TestRun(t *testing.T) {
+	a := assert.New(t)
+	dir := fixture(t)
+
+	for _, path := range []string{dir, filepath.Join(dir, "snapshot.json")} {
+		s, err := open(path)
+		require.NoError(t, err)
+		defer s.Close()
+
+		rows, err := run(s, "spaces", nil)
+		a.NoError(err)
+		a.Equal([]space{{ID: "space-1", Version: 2}}, rows)
+
+		rows, err = run(s, "clients", nil)
+		a.NoError(err)
+		if a.Len(rows, 1) {
+			a.Equal("client-1", rows.([]client)[0].ID)
+			a.Equal(uint64(2), rows.([]client)[0].LastMutationID)
+		}
+
+		rows, err = run(s, "entries", []string{"space-1", "todo/"})
+		a.NoError(err)
+		if a.Len(rows, 1) {
+			a.Equal("todo/2", rows.([]entry)[0].Key)
+			a.JSONEq(`"two"`, string(rows.([]entry)[0].Value))
+		}
+
+		rows, err = run(s, "changes", []string{"space-1", "1"})
+		a.NoError(err)
+		if a.Len(rows, 1) {
+			a.Equal("todo/1", rows.([]entry)[0].Key)
+			a.True(rows.([]entry)[0].Deleted)
+		}
+
+		// todo/1 changed after the toCookie, so only the others show.
+		rows, err = run(s, "changes", []string{"space-1", "0", "1"})
+		a.NoError(err)
+		a.Len(rows, 2)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	a := assert.New(t)
+
+	s, err := open(fixture(t))
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = run(s, "entries", nil)
+	a.Error(err)
+	_, err = run(s, "changes", []string{"space-1", "one"})
+	a.Error(err)
+	_, err = run(s, "spaces-and-clients", nil)
+	a.Error(err)
+
+	_, err = open(filepath.Join(t.TempDir(), "missing"))
+	a.Error(err)
+}
+
+func TestWriteTable(t *testing.T) {
+	a := assert.New(t)
+
+	s, err := open(fixture(t))
+	require.NoError(t, err)
+	defer s.Close()
+
+	rows, err := run(s, "changes", []string{"space-1", "0"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	a.NoError(writeTable(&buf, rows))
+	a.Contains(buf.String(), "KEY")
+	a.Contains(buf.String(), `"groceries"`)
+	a.Contains(buf.String(), "(deleted)")
+}
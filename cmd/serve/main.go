@@ -3,17 +3,19 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"strings"
 
 	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/chirouter"
 	"github.com/airheartdev/replicache/memory"
+	"github.com/airheartdev/replicache/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
-	"github.com/r3labs/sse/v2"
 )
 
 type Todo struct {
@@ -38,226 +40,207 @@ type CompleteTodos struct {
 	Completed bool     `json:"completed"`
 }
 
+var todoKey = replicache.StringKey("todo/")
+
 func main() {
-	router := chi.NewRouter()
-	router.Use(middleware.Logger)
-	router.Use(cors.Handler(cors.Options{
-		AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", replicache.ReplicacheRequestIDHeader},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
-
-	events := sse.New()
-	router.Get("/events", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Access-Control-Allow-Origin", "*")
-		events.ServeHTTP(w, r)
-	})
-	events.CreateStream("mutations")
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+
+	dataDir := flag.String("data", "", "directory to persist todos in; in memory only when empty")
+	recordFile := flag.String("record", "", "file to append push and pull traffic to, for replay")
+	strategyName := flag.String("strategy", "space", "sync strategy: space, global, reset or rows")
+	origins := flag.String("origins", "", "comma-separated origins allowed to make cross-origin requests")
+	flag.Parse()
+
+	strategy, err := newStrategy(*strategyName)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	be := memory.New[Todo]()
+	if *dataDir != "" {
+		be, err = memory.Open[Todo](*dataDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer be.Close()
+	}
 	// be.PutEntry("3s3rnj", "todo/ticker", Todo{
 	// 	ID:        "ticket",
 	// 	Text:      "Ticker",
 	// 	Completed: false,
 	// 	Sort:      0,
 	// }, 1)
-	lock := &sync.Mutex{}
-
-	rep := replicache.New[Todo](replicache.WithAuth(func(ctx context.Context, token string) bool {
-		// log.Println("Auth", token)
-		return true
-	}))
 
-	pullHandler := rep.HandlePull(func(pr *replicache.PullRequest, spaceID string) (replicache.PullResponse[Todo], error) {
-		// Poor man's transaction
-		lock.Lock()
-		defer lock.Unlock()
+	router := chi.NewRouter()
+	router.Use(middleware.Logger)
 
-		lastMutationID, ok := be.GetLastMutationID(pr.ClientID)
-		if !ok {
-			lastMutationID = 0
-		}
-		responseCookie, ok := be.GetCookie(spaceID)
-		if !ok {
-			responseCookie = 0
+	if *recordFile != "" {
+		f, err := os.OpenFile(*recordFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatal(err)
 		}
+		defer f.Close()
+		router.Use(replicache.Record(f))
+	}
 
-		resp := replicache.PullResponse[Todo]{
-			LastMutationID: lastMutationID,
-			Cookie:         responseCookie,
-			Patch:          []replicache.PatchOperation[Todo]{},
-		}
+	mount(router, be, strategy, replicache.WithAllowedOrigin(replicache.AllowOrigins(strings.Split(*origins, ",")...)))
 
-		if pr.Cookie == 0 {
-			resp.Patch = append(resp.Patch, replicache.PatchOperation[Todo]{
-				Op: replicache.PatchClear,
-			})
-		}
+	log.Println("Listening on http://localhost:1234")
+	log.Fatal(http.ListenAndServe("127.0.0.1:1234", router))
+}
 
-		entries := be.GetChangedEntries(spaceID, pr.Cookie)
-
-		for _, entry := range entries {
-			key := todoKey(entry.Key)
-			if entry.Deleted {
-				resp.Patch = append(resp.Patch, replicache.PatchOperation[Todo]{
-					Op:  replicache.PatchDel,
-					Key: &key,
-				})
-			} else {
-				resp.Patch = append(resp.Patch, replicache.PatchOperation[Todo]{
-					Op:    replicache.PatchPut,
-					Key:   &key,
-					Value: &entry.Value,
-				})
-			}
+// replay replays a capture written with -record against a fresh in-memory
+// store and prints every response which differs from the capture.
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	strategyName := fs.String("strategy", "space", "sync strategy the capture was recorded with")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: serve replay [-strategy name] <capture.jsonl>")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	strategy, err := newStrategy(*strategyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	router := chi.NewRouter()
+	mount(router, memory.New[Todo](), strategy)
+
+	diffs, err := replicache.Replay(router, f)
+	for _, diff := range diffs {
+		fmt.Println(diff)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// newStrategy returns the sync strategy called name.
+func newStrategy(name string) (replicache.SyncStrategy[Todo], error) {
+	switch name {
+	case "space":
+		return replicache.PerSpaceVersion[Todo](), nil
+	case "global":
+		return replicache.GlobalVersion[Todo](), nil
+	case "reset":
+		return replicache.AlwaysReset[Todo](), nil
+	case "rows":
+		return replicache.RowVersioning[Todo](), nil
+	}
+	return nil, fmt.Errorf("unknown sync strategy %q", name)
+}
+
+func mount(router chi.Router, be *memory.MemoryBackend[Todo], strategy replicache.SyncStrategy[Todo], options ...replicache.Option) {
+	prom := metrics.NewPrometheus()
+	rep := replicache.New[Todo](append([]replicache.Option{
+		replicache.WithAuth(func(ctx context.Context, token string) (any, bool) {
+			// log.Println("Auth", token)
+			return nil, true
+		}),
+		replicache.WithMetrics(prom),
+		// A bad mutation must not block its client forever.
+		replicache.WithFailurePolicy(replicache.SkipOnFailure),
+	}, options...)...)
+	rep.SetStore(be)
+	rep.SetStrategy(strategy)
+	registerMutators(rep)
+
+	chirouter.Mount(router, rep)
+	// The poke stream of the default space, where earlier clients listen.
+	// They pass their ID as the clientID query parameter.
+	router.Handle("/events", rep.CORS(rep.HandlePoke()))
+	router.Handle("/metrics", prom)
+}
+
+func registerMutators(rep *replicache.Replicache[Todo]) {
+	rep.Register("putTodo", func(ctx context.Context, tx replicache.ReadWriteTransaction[Todo], mut replicache.Mutation) error {
+		newTodo := new(Todo)
+		err := json.Unmarshal(mut.Args, newTodo)
+		if err != nil {
+			log.Printf("Error unmarshalling putTodo(): %s", err)
+			return err
 		}
 
-		return resp, nil
+		return replicache.Put(tx, todoKey, newTodo.ID, newTodo)
 	})
 
-	pushHandler := rep.HandlePush(func(pr *replicache.PushRequest, spaceID string) error {
-		lock.Lock()
-		defer lock.Unlock()
-
-		prevVersion, ok := be.GetCookie(spaceID)
-		if !ok {
-			prevVersion = 0
+	rep.Register("updateTodo", func(ctx context.Context, tx replicache.ReadWriteTransaction[Todo], mut replicache.Mutation) error {
+		update := new(UpdateTodo)
+		err := json.Unmarshal(mut.Args, update)
+		if err != nil {
+			log.Printf("Error unmarshalling updateTodo(): %s", err)
+			return err
 		}
 
-		nextVersion := prevVersion + 1
-		lastMutationID, ok := be.GetLastMutationID(pr.ClientID)
-		if !ok {
-			lastMutationID = 0
+		todo, err := replicache.Get(tx, todoKey, update.ID)
+		if err != nil {
+			return err
 		}
 
-		// log.Printf("nextVersion: %d", nextVersion)
-		// log.Printf("lastMutationID: %d", lastMutationID)
+		if todo.Completed != update.Changes.Completed {
+			todo.Completed = update.Changes.Completed
+		}
+		if todo.Sort != update.Changes.Sort {
+			todo.Sort = update.Changes.Sort
+		}
+		if todo.Text != update.Changes.Text && update.Changes.Text != "" {
+			todo.Text = update.Changes.Text
+		}
 
-		tx := memory.ReplicacheTransaction[Todo](be, spaceID, pr.ClientID, nextVersion)
+		return replicache.Put(tx, todoKey, todo.ID, todo)
+	})
 
-		for _, mut := range pr.Mutations {
-			expectedMutationID := lastMutationID + 1
-			if mut.ID < expectedMutationID {
-				log.Printf("Mutation %d has already been processed - skipping ", mut.ID)
-				continue
+	rep.Register("deleteTodos", func(ctx context.Context, tx replicache.ReadWriteTransaction[Todo], mut replicache.Mutation) error {
+		ids := []string{}
+		err := json.Unmarshal(mut.Args, &ids)
+		if err != nil {
+			log.Printf("Error unmarshalling deleteTodos(): %s - '%s'", err, string(mut.Args))
+			return err
+		}
+		for _, id := range ids {
+			if err := replicache.Del(tx, todoKey, id); err != nil {
+				return err
 			}
+		}
+		return nil
+	})
 
-			if mut.ID > expectedMutationID {
-				log.Printf("Mutation %d is from the future - aborting", mut.ID)
-				break
-			}
+	rep.Register("completeTodos", func(ctx context.Context, tx replicache.ReadWriteTransaction[Todo], mut replicache.Mutation) error {
+		change := new(CompleteTodos)
+		err := json.Unmarshal(mut.Args, change)
+		if err != nil {
+			log.Printf("Error unmarshalling completeTodos(): %s", err)
+			return err
+		}
 
-			log.Printf("Processing mutation (%s): %s", mut.Name, string(mut.Args))
-
-			switch mut.Name {
-			case "putTodo":
-				newTodo := new(Todo)
-				err := json.Unmarshal(mut.Args, newTodo)
-				if err != nil {
-					log.Printf("Error unmarshalling putTodo(): %s", err)
-					return err
-				}
-
-				tx.Put(todoKey(newTodo.ID), newTodo)
-
-				// err = be.PutEntry(spaceID, todoKey(newTodo.ID), newTodo, nextVersion)
-				// if err != nil {
-				// 	log.Printf("Error putting entry: %s", err)
-				// 	return err
-				// }
-
-			case "updateTodo":
-				update := new(UpdateTodo)
-				err := json.Unmarshal(mut.Args, update)
-				if err != nil {
-					log.Printf("Error unmarshalling updateTodo(): %s", err)
-					return err
-				}
-
-				// log.Printf("Update todo: %s", update.ID)
-
-				todo, err := tx.Get(update.ID)
-				if err != nil {
-					return err
-				}
-
-				// value, err := be.GetEntry(spaceID, todoKey(update.ID))
-				// if err != nil {
-				// 	return err
-				// }
-
-				if todo.Completed != update.Changes.Completed {
-					todo.Completed = update.Changes.Completed
-				}
-				if todo.Sort != update.Changes.Sort {
-					todo.Sort = update.Changes.Sort
-				}
-				if todo.Text != update.Changes.Text && update.Changes.Text != "" {
-					todo.Text = update.Changes.Text
-				}
-
-				tx.Put(todoKey(todo.ID), todo)
-
-				// be.PutEntry(spaceID, todoKey(update.ID), todo, nextVersion)
-
-			case "deleteTodos":
-				ids := []string{}
-				err := json.Unmarshal(mut.Args, &ids)
-				if err != nil {
-					log.Printf("Error unmarshalling updateTodo(): %s - '%s'", err, string(mut.Args))
-					return err
-				}
-				for _, id := range ids {
-					tx.Del(todoKey(id))
-				}
-
-			case "completeTodos":
-				change := new(CompleteTodos)
-				err := json.Unmarshal(mut.Args, change)
-				if err != nil {
-					log.Printf("Error unmarshalling updateTodo(): %s", err)
-					return err
-				}
-
-				for _, id := range change.IDs {
-					todo, err := tx.Get(todoKey(id))
-					if err != nil {
-						return err
-					}
-
-					todo.Completed = change.Completed
-					tx.Put(todoKey(id), todo)
-				}
+		for _, id := range change.IDs {
+			todo, err := replicache.Get(tx, todoKey, id)
+			if err != nil {
+				return err
 			}
 
-			lastMutationID = expectedMutationID
+			todo.Completed = change.Completed
+			if err := replicache.Put(tx, todoKey, id, todo); err != nil {
+				return err
+			}
 		}
-
-		be.SetLastMutationID(pr.ClientID, lastMutationID)
-		be.SetCookie(spaceID, nextVersion)
-		tx.Flush()
-
-		events.Publish("mutations", &sse.Event{
-			Data: []byte("ping"),
-		})
-
 		return nil
 	})
-
-	router.Post(replicache.DefaultPullEndpoint, pullHandler)
-	router.Post(replicache.DefaultPushEndpoint, pushHandler)
-
-	log.Println("Listening on http://localhost:1234")
-	log.Fatal(http.ListenAndServe("127.0.0.1:1234", router))
-}
-
-func todoKey(id string) string {
-	return fmt.Sprintf("todo/%s", id)
-}
-
-func idFromKey(key string) string {
-	return key[5:]
 }
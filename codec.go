@@ -0,0 +1,24 @@
+package replicache
+
+import "encoding/json"
+
+type (
+	// Codec encodes values for storage.
+	Codec[T any] interface {
+		Marshal(value T) ([]byte, error)
+		Unmarshal(data []byte) (T, error)
+	}
+
+	// JSONCodec is the default Codec.
+	JSONCodec[T any] struct{}
+)
+
+func (JSONCodec[T]) Marshal(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
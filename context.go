@@ -0,0 +1,32 @@
+package replicache
+
+import "context"
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying principal.
+func ContextWithPrincipal(ctx context.Context, principal any) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal returned by the AuthFn for the
+// current request.
+func PrincipalFromContext(ctx context.Context) (any, bool) {
+	principal := ctx.Value(principalKey{})
+	return principal, principal != nil
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the Replicache request
+// ID.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the X-Replicache-RequestID of the current
+// request, or an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
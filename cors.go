@@ -0,0 +1,56 @@
+package replicache
+
+import (
+	"net/http"
+	"strings"
+)
+
+var corsAllowedHeaders = strings.Join([]string{"Accept", authorizationHeader, "Content-Type", ReplicacheRequestIDHeader}, ", ")
+
+// WithAllowedOrigin sets which origins may make cross-origin requests to the
+// endpoints registered by Mount. Allowed origins may send credentials, so no
+// origin is allowed by default.
+func WithAllowedOrigin(fn func(origin string) bool) Option {
+	return func(o *Options) {
+		o.allowOrigin = fn
+	}
+}
+
+// AllowOrigins returns a WithAllowedOrigin function allowing exactly
+// origins, e.g. "https://app.example.com".
+func AllowOrigins(origins ...string) func(origin string) bool {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return func(origin string) bool {
+		return allowed[origin]
+	}
+}
+
+// CORS wraps next with the CORS headers required by Replicache clients,
+// answering preflight requests itself.
+func (r *Replicache[T]) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || r.options.allowOrigin == nil || !r.options.allowOrigin(origin) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", "300")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,112 @@
+package replicache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRequestInFlight is returned for a push whose request ID is still being
+// processed by an earlier attempt.
+var ErrRequestInFlight = errors.New("request in flight")
+
+// WithRequestDedupe remembers successful pushes by client, space and request
+// ID for ttl. A retried push from the same client to the same space with the
+// same request ID is acknowledged without being processed again, and a retry
+// which arrives while the push is still being processed is rejected with
+// ErrRequestInFlight. Failed pushes are not remembered, so they can be
+// retried.
+func WithRequestDedupe(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.dedupeTTL = ttl
+	}
+}
+
+type (
+	requestCache struct {
+		mu  sync.Mutex
+		ttl time.Duration
+		// entries holds the expiry of each completed request, and the zero
+		// time for those in flight.
+		entries map[requestKey]time.Time
+		// expiries holds the completed requests in the order they expire,
+		// which is the order they completed in as ttl is fixed.
+		expiries []requestExpiry
+	}
+
+	// requestKey scopes a request ID to the client and space it was sent
+	// by, as request IDs are only unique per client.
+	requestKey struct {
+		clientID  string
+		spaceID   string
+		requestID string
+	}
+
+	requestExpiry struct {
+		key     requestKey
+		expires time.Time
+	}
+
+	requestState int
+)
+
+const (
+	requestNew requestState = iota
+	requestInFlight
+	requestDone
+)
+
+func newRequestCache(ttl time.Duration) *requestCache {
+	return &requestCache{
+		ttl:     ttl,
+		entries: make(map[requestKey]time.Time),
+	}
+}
+
+// reserve marks key as in flight, unless it already is or has completed.
+// It returns the state key was in, so only a request seen as requestNew may
+// be processed, and must then be passed to release.
+func (c *requestCache) reserve(key requestKey) requestState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(time.Now())
+	expires, ok := c.entries[key]
+	switch {
+	case !ok:
+		c.entries[key] = time.Time{}
+		return requestNew
+	case expires.IsZero():
+		return requestInFlight
+	default:
+		return requestDone
+	}
+}
+
+// release ends the processing of a reserved key. The key is remembered for
+// ttl if the request succeeded, and forgotten so it can be retried if not.
+func (c *requestCache) release(key requestKey, succeeded bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !succeeded {
+		delete(c.entries, key)
+		return
+	}
+	expires := time.Now().Add(c.ttl)
+	c.entries[key] = expires
+	c.expiries = append(c.expiries, requestExpiry{key: key, expires: expires})
+}
+
+// sweep forgets the requests which expired by now, from the front of the
+// queue, so each push only visits the entries expiring since the last.
+func (c *requestCache) sweep(now time.Time) {
+	n := 0
+	for ; n < len(c.expiries) && now.After(c.expiries[n].expires); n++ {
+		e := c.expiries[n]
+		if c.entries[e.key].Equal(e.expires) {
+			delete(c.entries, e.key)
+		}
+	}
+	c.expiries = c.expiries[n:]
+}
@@ -0,0 +1,46 @@
+// Package echorouter mounts Replicache endpoints on an echo server.
+package echorouter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/airheartdev/replicache"
+	"github.com/labstack/echo/v4"
+)
+
+// Mount registers the push, pull and poke endpoints of rep on e. Path
+// parameters are copied to the request so the default space resolver can
+// read them.
+func Mount[T any](e *echo.Echo, rep *replicache.Replicache[T]) {
+	MountRoutes(e, rep.Routes())
+}
+
+// MountRoutes registers routes, e.g. from Replicache.RoutesWith, on e.
+func MountRoutes(e *echo.Echo, routes []replicache.Route) {
+	for _, route := range routes {
+		e.Any(echoPath(route.Path), wrap(route.Handler))
+	}
+}
+
+func wrap(h http.Handler) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		for i, name := range c.ParamNames() {
+			req.SetPathValue(name, c.ParamValues()[i])
+		}
+		h.ServeHTTP(c.Response(), req)
+		return nil
+	}
+}
+
+// echoPath converts {name} wildcards to echo's :name parameters.
+func echoPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = ":" + segment[1:len(segment)-1]
+		}
+	}
+	return strings.Join(segments, "/")
+}
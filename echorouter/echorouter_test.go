@@ -0,0 +1,38 @@
+package echorouter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMount(t *testing.T) {
+	a := assert.New(t)
+
+	var spaceID string
+	rep := replicache.New[string](replicache.WithSpaceAuthorizer(func(ctx context.Context, principal any, id string, op replicache.Operation) error {
+		spaceID = id
+		return nil
+	}))
+	rep.SetStore(memory.New[string]())
+
+	e := echo.New()
+	Mount(e, rep)
+
+	req := httptest.NewRequest(http.MethodPost, replicache.DefaultPullEndpoint+"/space-1", strings.NewReader(`{"clientID":"c1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(replicache.ReplicacheRequestIDHeader, "1")
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("space-1", spaceID)
+}
@@ -1,5 +1,52 @@
 package replicache
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrMutatorExists = errors.New("mutator already exists")
+var ErrMutatorNotFound = errors.New("mutator not found")
+var ErrNoStore = errors.New("no store configured")
+
+// ErrNotFound is returned by a Backend for an entry which does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrClientStateNotFound is returned when a client has mutation state the
+// store has no record of, e.g. because the client was pruned.
+var ErrClientStateNotFound = errors.New("ClientStateNotFound")
+
+// ErrInvalidSavepoint is returned when rolling back to a savepoint which
+// was released or never taken.
+var ErrInvalidSavepoint = errors.New("invalid savepoint")
+
+// StatusError is an error which a request is answered with Status for,
+// e.g. a hook rejecting it with a 4xx status. Its message is sent to the
+// client unless Status is 5xx.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is the error of a mutator which panicked.
+type PanicError struct {
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("mutator panicked: %v", e.Value)
+}
+
+// ErrTransactionClosed is returned by the transaction of a mutator which
+// timed out, as Push has moved on without it.
+var ErrTransactionClosed = errors.New("transaction closed")
@@ -0,0 +1,75 @@
+package replicache
+
+import "sync"
+
+// guardedTransaction is handed to a mutator which runs with a deadline.
+// Once the deadline passes Push closes it, waiting for any call in flight,
+// so the abandoned mutator cannot write behind Push's back.
+type guardedTransaction[T any] struct {
+	tx     ReadWriteTransaction[T]
+	mu     sync.Mutex
+	closed bool
+}
+
+func (g *guardedTransaction[T]) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+}
+
+func (g *guardedTransaction[T]) Get(key string) (*T, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil, ErrTransactionClosed
+	}
+	return g.tx.Get(key)
+}
+
+func (g *guardedTransaction[T]) Has(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.closed && g.tx.Has(key)
+}
+
+func (g *guardedTransaction[T]) IsEmpty() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed || g.tx.IsEmpty()
+}
+
+func (g *guardedTransaction[T]) Put(key string, value *T) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrTransactionClosed
+	}
+	return g.tx.Put(key, value)
+}
+
+func (g *guardedTransaction[T]) Del(key string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrTransactionClosed
+	}
+	return g.tx.Del(key)
+}
+
+func (g *guardedTransaction[T]) Flush() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrTransactionClosed
+	}
+	return g.tx.Flush()
+}
+
+func (g *guardedTransaction[T]) Changes() []Entry[T] {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil
+	}
+	return g.tx.Changes()
+}
@@ -1,9 +1,11 @@
 package replicache
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"time"
 )
 
 const DefaultPushEndpoint = "/replicache-push"
@@ -12,84 +14,265 @@ const applicationJSON = "application/json"
 const ReplicacheRequestIDHeader = "X-Replicache-RequestID"
 const authorizationHeader = "Authorization"
 
-func (r *Replicache[T]) HandlePush(fn func(pr *PushRequest, spaceID string) error) http.HandlerFunc {
+type (
+	// PushFunc processes a push to spaceID. Push is the built-in one.
+	PushFunc func(ctx context.Context, pr *PushRequest, spaceID string) error
+
+	// PullFunc answers a pull from spaceID. Pull is the built-in one.
+	PullFunc[T any] func(ctx context.Context, pr *PullRequest, spaceID string) (PullResponse[T], error)
+)
+
+// ErrorResponse is the body written when a push or pull fails.
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"requestID,omitempty"`
+}
+
+func (r *Replicache[T]) HandlePush(fn PushFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		if !validateRequest(w, req, r.options.authFn) {
+		ctx, span := r.options.tracer.Start(req.Context(), "replicache.push")
+		defer span.End()
+
+		ctx, ok := r.validateRequest(w, req.WithContext(ctx), OperationPush)
+		if !ok {
 			return
 		}
 
 		push := new(PushRequest)
 		err := json.NewDecoder(req.Body).Decode(push)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		if r.isRevoked(push.ClientID) {
+			writeError(ctx, w, http.StatusForbidden, ErrClientRevoked)
 			return
 		}
 
-		spaceID := req.URL.Query().Get("spaceID")
-		err = fn(push, spaceID)
+		spaceID, ok := r.resolveSpace(w, req.WithContext(ctx))
+		if !ok {
+			return
+		}
+		span.SetAttribute("replicache.space_id", spaceID)
+		span.SetAttribute("replicache.client_id", push.ClientID)
+		if !r.authorizeSpace(ctx, w, spaceID, OperationPush) {
+			return
+		}
+		if !r.rateLimit(ctx, w, OperationPush, push.ClientID, spaceID) {
+			return
+		}
+
+		request := requestKey{push.ClientID, spaceID, RequestIDFromContext(ctx)}
+		if r.requests != nil {
+			switch r.requests.reserve(request) {
+			case requestDone:
+				w.WriteHeader(http.StatusOK)
+				return
+			case requestInFlight:
+				writeError(ctx, w, http.StatusConflict, ErrRequestInFlight)
+				return
+			}
+		}
+
+		start := time.Now()
+		err = fn(ctx, push, spaceID)
+		if r.requests != nil {
+			r.requests.release(request, err == nil)
+		}
+		duration := time.Since(start)
+		r.options.metrics.ObservePush(duration, err)
+		logger := r.logger(ctx).With("spaceID", spaceID, "clientID", push.ClientID, "duration", duration)
+		if err != nil {
+			span.RecordError(err)
+		}
+		if errors.Is(err, ErrClientStateNotFound) {
+			logger.InfoContext(ctx, "push from unknown client")
+			writeClientStateNotFound(w)
+			return
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			logger.WarnContext(ctx, "push over quota", "error", err)
+			writeError(ctx, w, http.StatusInsufficientStorage, err)
+			return
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			logger.WarnContext(ctx, "push rejected", "status", statusErr.Status, "error", err)
+			writeError(ctx, w, statusErr.Status, err)
+			return
+		}
 		if err != nil {
-			log.Printf("Push Error: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.ErrorContext(ctx, "push failed", "error", err)
+			writeError(ctx, w, http.StatusInternalServerError, err)
 			return
 		}
 
+		logger.DebugContext(ctx, "push handled", "mutations", len(push.Mutations))
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func (r *Replicache[T]) HandlePull(fn func(pr *PullRequest, spaceID string) (PullResponse[T], error)) http.HandlerFunc {
+func (r *Replicache[T]) HandlePull(fn PullFunc[T]) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		if !validateRequest(w, req, r.options.authFn) {
+		ctx, span := r.options.tracer.Start(req.Context(), "replicache.pull")
+		defer span.End()
+
+		ctx, ok := r.validateRequest(w, req.WithContext(ctx), OperationPull)
+		if !ok {
 			return
 		}
 
 		pull := new(PullRequest)
 		err := json.NewDecoder(req.Body).Decode(pull)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+		if r.isRevoked(pull.ClientID) {
+			writeError(ctx, w, http.StatusForbidden, ErrClientRevoked)
 			return
 		}
 
-		spaceID := req.URL.Query().Get("spaceID")
+		spaceID, ok := r.resolveSpace(w, req.WithContext(ctx))
+		if !ok {
+			return
+		}
+		span.SetAttribute("replicache.space_id", spaceID)
+		span.SetAttribute("replicache.client_id", pull.ClientID)
+		if !r.authorizeSpace(ctx, w, spaceID, OperationPull) {
+			return
+		}
+		if !r.rateLimit(ctx, w, OperationPull, pull.ClientID, spaceID) {
+			return
+		}
 
-		resp, err := fn(pull, spaceID)
+		start := time.Now()
+		resp, err := fn(ctx, pull, spaceID)
+		duration := time.Since(start)
+		reset := len(resp.Patch) > 0 && resp.Patch[0].Op == PatchClear
+		r.options.metrics.ObservePull(duration, len(resp.Patch), reset, err)
+		logger := r.logger(ctx).With("spaceID", spaceID, "clientID", pull.ClientID, "duration", duration)
+		if err != nil {
+			span.RecordError(err)
+		}
+		if errors.Is(err, ErrClientStateNotFound) {
+			logger.InfoContext(ctx, "pull from unknown client")
+			writeClientStateNotFound(w)
+			return
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			logger.WarnContext(ctx, "pull rejected", "status", statusErr.Status, "error", err)
+			writeError(ctx, w, statusErr.Status, err)
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.ErrorContext(ctx, "pull failed", "error", err)
+			writeError(ctx, w, http.StatusInternalServerError, err)
 			return
 		}
 
+		logger.DebugContext(ctx, "pull handled", "cookie", pull.Cookie, "patch", len(resp.Patch), "reset", reset)
 		w.Header().Set("Content-Type", applicationJSON)
 		err = json.NewEncoder(w).Encode(resp)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.ErrorContext(ctx, "writing pull response", "error", err)
 			return
 		}
 	}
 }
 
-func validateRequest(w http.ResponseWriter, r *http.Request, authFn AuthFn) bool {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return false
+func (r *Replicache[T]) authorizeSpace(ctx context.Context, w http.ResponseWriter, spaceID string, op Operation) bool {
+	if r.options.spaceAuthorizer == nil {
+		return true
 	}
 
-	if r.Header.Get("Content-Type") != applicationJSON {
-		w.WriteHeader(http.StatusBadRequest)
+	principal, _ := PrincipalFromContext(ctx)
+	actx, span := r.options.tracer.Start(ctx, "replicache.authorize")
+	err := r.options.spaceAuthorizer(actx, principal, spaceID, op)
+	endSpan(span, err)
+	if err != nil {
+		r.options.metrics.AuthFailed(op)
+		r.logger(ctx).WarnContext(ctx, "space not authorized", "spaceID", spaceID, "operation", op, "error", err)
+		writeError(ctx, w, http.StatusForbidden, nil)
 		return false
 	}
 
-	if requestID := r.Header.Get(ReplicacheRequestIDHeader); requestID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		return false
+	return true
+}
+
+func (r *Replicache[T]) validateRequest(w http.ResponseWriter, req *http.Request, op Operation) (context.Context, bool) {
+	requestID := req.Header.Get(ReplicacheRequestIDHeader)
+	ctx := ContextWithRequestID(req.Context(), requestID)
+
+	if req.Method != http.MethodPost {
+		writeError(ctx, w, http.StatusMethodNotAllowed, nil)
+		return ctx, false
 	}
 
-	if authFn != nil {
-		auth := r.Header.Get(authorizationHeader)
-		if !authFn(r.Context(), auth) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return false
-		}
+	if req.Header.Get("Content-Type") != applicationJSON {
+		writeError(ctx, w, http.StatusBadRequest, errors.New("content type must be "+applicationJSON))
+		return ctx, false
 	}
 
-	return true
+	if requestID == "" {
+		writeError(ctx, w, http.StatusBadRequest, errors.New(ReplicacheRequestIDHeader+" header is required"))
+		return ctx, false
+	}
+
+	return r.authenticate(ctx, w, req.Header.Get(authorizationHeader), op)
+}
+
+// authenticate runs the AuthFn on token, and returns ctx carrying the
+// principal it returns. It writes a 401 response if the token is rejected.
+func (r *Replicache[T]) authenticate(ctx context.Context, w http.ResponseWriter, token string, op Operation) (context.Context, bool) {
+	authFn := r.options.authFn
+	if authFn == nil {
+		return ctx, true
+	}
+
+	actx, span := r.options.tracer.Start(ctx, "replicache.auth")
+	principal, ok := authFn(actx, token)
+	span.SetAttribute("replicache.authenticated", ok)
+	span.End()
+	if !ok {
+		r.options.metrics.AuthFailed(op)
+		writeError(ctx, w, http.StatusUnauthorized, nil)
+		return ctx, false
+	}
+	if principal != nil {
+		ctx = ContextWithPrincipal(ctx, principal)
+	}
+	return ctx, true
+}
+
+// writeError writes an ErrorResponse carrying the request ID from ctx. A nil
+// err, or any err with a 5xx status, is described by the status text, so
+// the details of server failures don't reach clients. Callers log those.
+func writeError(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	message := http.StatusText(status)
+	if err != nil && status < http.StatusInternalServerError {
+		message = err.Error()
+	}
+
+	w.Header().Set("Content-Type", applicationJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error:     message,
+		RequestID: RequestIDFromContext(ctx),
+	})
+}
+
+// writeServerError logs err, and writes a response with status which only
+// carries the status text.
+func (r *Replicache[T]) writeServerError(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	r.logger(ctx).ErrorContext(ctx, "request failed", "status", status, "error", err)
+	writeError(ctx, w, status, err)
+}
+
+// writeClientStateNotFound asks the client to reset. Replicache expects this
+// as a successful response.
+func writeClientStateNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", applicationJSON)
+	json.NewEncoder(w).Encode(ClientStateNotFoundResponse{Error: ErrClientStateNotFound.Error()})
 }
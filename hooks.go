@@ -0,0 +1,94 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type (
+	// MutatorMiddleware wraps every mutator invocation, e.g. to validate
+	// arguments or check permissions. It also sees mutations without a
+	// registered mutator, for which next returns ErrMutatorNotFound.
+	MutatorMiddleware[T any] func(next Mutator[T]) Mutator[T]
+
+	// BeforePushHook is called before a push is processed. A non-nil error
+	// rejects the push without applying any of its mutations. It is answered
+	// with the status of a *StatusError, or 400 Bad Request for any other
+	// error.
+	BeforePushHook func(ctx context.Context, pr *PushRequest, spaceID string) error
+
+	// AfterCommitHook is called with every change set committed by Push or
+	// Transact, once it is durable.
+	AfterCommitHook[T any] func(ctx context.Context, cs ChangeSet[T])
+
+	// PullHook is called with the response to a pull before it is returned,
+	// and may change it. A non-nil error fails the pull, with the status of a
+	// *StatusError or 500 Internal Server Error.
+	PullHook[T any] func(ctx context.Context, pr *PullRequest, spaceID string, resp *PullResponse[T]) error
+
+	hooks[T any] struct {
+		middleware  []MutatorMiddleware[T]
+		beforePush  []BeforePushHook
+		afterCommit []AfterCommitHook[T]
+		onPull      []PullHook[T]
+	}
+)
+
+// Use adds middleware around every mutator. The first middleware added is
+// the outermost.
+func (r *Replicache[T]) Use(middleware ...MutatorMiddleware[T]) {
+	r.hooks.middleware = append(r.hooks.middleware, middleware...)
+}
+
+// BeforePush adds a hook which is called before each push.
+func (r *Replicache[T]) BeforePush(hook BeforePushHook) {
+	r.hooks.beforePush = append(r.hooks.beforePush, hook)
+}
+
+// AfterCommit adds a hook which is called after each commit.
+func (r *Replicache[T]) AfterCommit(hook AfterCommitHook[T]) {
+	r.hooks.afterCommit = append(r.hooks.afterCommit, hook)
+}
+
+// OnPull adds a hook which is called with each pull response.
+func (r *Replicache[T]) OnPull(hook PullHook[T]) {
+	r.hooks.onPull = append(r.hooks.onPull, hook)
+}
+
+// dispatch invokes the registered mutator for m.
+func (r *Replicache[T]) dispatch(ctx context.Context, tx ReadWriteTransaction[T], m Mutation) error {
+	mutator, ok := r.mutators[m.Name]
+	if !ok {
+		return ErrMutatorNotFound
+	}
+	return mutator(ctx, tx, m)
+}
+
+func (r *Replicache[T]) runBeforePush(ctx context.Context, pr *PushRequest, spaceID string) error {
+	for _, hook := range r.hooks.beforePush {
+		if err := hook(ctx, pr, spaceID); err != nil {
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) {
+				err = &StatusError{Status: http.StatusBadRequest, Err: err}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replicache[T]) runAfterCommit(ctx context.Context, cs ChangeSet[T]) {
+	for _, hook := range r.hooks.afterCommit {
+		hook(ctx, cs)
+	}
+}
+
+func (r *Replicache[T]) runOnPull(ctx context.Context, pr *PullRequest, spaceID string, resp *PullResponse[T]) error {
+	for _, hook := range r.hooks.onPull {
+		if err := hook(ctx, pr, spaceID, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package replicache_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/airheartdev/replicache"
+)
+
+func (s *SyncSuite) TestMutatorMiddleware() {
+	var calls []string
+	trace := func(name string) replicache.MutatorMiddleware[string] {
+		return func(next replicache.Mutator[string]) replicache.Mutator[string] {
+			return func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+				calls = append(calls, name+":"+m.Name)
+				return next(ctx, tx, m)
+			}
+		}
+	}
+	s.rep.Use(trace("outer"), trace("inner"))
+	s.rep.Use(func(next replicache.Mutator[string]) replicache.Mutator[string] {
+		return func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+			if m.Name == "del" {
+				return errors.New("deletes are disabled")
+			}
+			return next(ctx, tx, m)
+		}
+	})
+
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)})
+	s.Equal([]string{"outer:put", "inner:put"}, calls)
+
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: []replicache.Mutation{{ID: 2, Name: "del", Args: json.RawMessage(`"todo/1"`)}},
+	})
+	s.Equal(http.StatusInternalServerError, w.Code)
+}
+
+func (s *SyncSuite) TestHooks() {
+	s.rep.BeforePush(func(ctx context.Context, pr *replicache.PushRequest, spaceID string) error {
+		if spaceID == "readonly" {
+			return errors.New("space is read-only")
+		}
+		if spaceID == "private" {
+			return &replicache.StatusError{Status: http.StatusForbidden, Err: errors.New("space is private")}
+		}
+		return nil
+	})
+
+	var committed []replicache.ChangeSet[string]
+	s.rep.AfterCommit(func(ctx context.Context, cs replicache.ChangeSet[string]) {
+		committed = append(committed, cs)
+	})
+
+	s.rep.OnPull(func(ctx context.Context, pr *replicache.PullRequest, spaceID string, resp *replicache.PullResponse[string]) error {
+		key, value := "server/time", "now"
+		resp.Patch = append(resp.Patch, replicache.PatchOperation[string]{Op: replicache.PatchPut, Key: &key, Value: &value})
+		return nil
+	})
+
+	w := s.post(replicache.DefaultPushEndpoint+"/readonly", replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: []replicache.Mutation{{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)}},
+	})
+	s.Equal(http.StatusBadRequest, w.Code)
+	s.Contains(w.Body.String(), "space is read-only")
+	s.Empty(committed)
+
+	w = s.post(replicache.DefaultPushEndpoint+"/private", replicache.PushRequest{ClientID: "client-1"})
+	s.Equal(http.StatusForbidden, w.Code)
+	s.Contains(w.Body.String(), "space is private")
+
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)})
+	s.Require().Len(committed, 1)
+	s.Equal("space-1", committed[0].SpaceID)
+	s.Equal(uint64(1), committed[0].LastMutationID)
+	s.Require().Len(committed[0].Entries, 1)
+	s.Equal("todo/1", committed[0].Entries[0].Key)
+
+	resp := s.pull(replicache.DefaultPullEndpoint+"/space-1", 0)
+	s.Require().Len(resp.Patch, 3)
+	s.Equal("server/time", *resp.Patch[2].Key)
+}
@@ -0,0 +1,133 @@
+package replicache
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid key")
+
+// Key builds and parses the keys of one kind of entry, which are its prefix
+// followed by an encoded ID.
+type Key[ID any] struct {
+	prefix string
+	encode func(id ID) (string, error)
+	decode func(s string) (ID, error)
+}
+
+// NewKey returns a Key which encodes IDs after prefix with encode, and
+// decodes them with decode. encode must not produce an empty string.
+func NewKey[ID any](prefix string, encode func(id ID) (string, error), decode func(s string) (ID, error)) Key[ID] {
+	return Key[ID]{prefix: prefix, encode: encode, decode: decode}
+}
+
+// StringKey returns a Key for string IDs, which are used as they are, so its
+// keys match those the client builds from the same IDs. IDs containing the
+// separator "/" are invalid, so a key under a nested prefix is never
+// mistaken for one of this Key. Empty IDs are invalid too. Use
+// EscapedStringKey for IDs which may contain "/" and whose keys are only
+// built on the server.
+func StringKey(prefix string) Key[string] {
+	return NewKey(prefix, plainID, plainID)
+}
+
+// EscapedStringKey returns a Key for string IDs which are path escaped, so
+// any non-empty ID is valid, including paths and URLs. Escaped keys differ
+// from those a client builds from IDs it doesn't escape the same way.
+func EscapedStringKey(prefix string) Key[string] {
+	return NewKey(prefix,
+		func(id string) (string, error) { return url.PathEscape(id), nil },
+		func(s string) (string, error) {
+			if _, err := plainID(s); err != nil {
+				return "", err
+			}
+			return url.PathUnescape(s)
+		},
+	)
+}
+
+func plainID(id string) (string, error) {
+	if strings.Contains(id, "/") {
+		return "", fmt.Errorf("ID %q contains \"/\"", id)
+	}
+	return id, nil
+}
+
+func (k Key[ID]) Prefix() string {
+	return k.prefix
+}
+
+// Encode returns the key for id.
+func (k Key[ID]) Encode(id ID) (string, error) {
+	s, err := k.encode(id)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidKey, err)
+	}
+	if s == "" {
+		return "", fmt.Errorf("%w: empty ID for %q", ErrInvalidKey, k.prefix)
+	}
+	return k.prefix + s, nil
+}
+
+// Decode returns the ID of key, or ErrInvalidKey if key doesn't belong to k.
+func (k Key[ID]) Decode(key string) (ID, error) {
+	var id ID
+	s, ok := strings.CutPrefix(key, k.prefix)
+	if !ok || s == "" {
+		return id, fmt.Errorf("%w: %q is not a %q key", ErrInvalidKey, key, k.prefix)
+	}
+
+	id, err := k.decode(s)
+	if err != nil {
+		return id, fmt.Errorf("%w: %q: %s", ErrInvalidKey, key, err)
+	}
+	return id, nil
+}
+
+// Match reports whether key belongs to k.
+func (k Key[ID]) Match(key string) bool {
+	_, err := k.Decode(key)
+	return err == nil
+}
+
+// Range returns the bounds of the keys belonging to k, for ordered scans:
+// start is inclusive and end exclusive. end is empty when the prefix is.
+func (k Key[ID]) Range() (start string, end string) {
+	prefix := []byte(k.prefix)
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			prefix[i]++
+			return k.prefix, string(prefix[:i+1])
+		}
+	}
+	return k.prefix, ""
+}
+
+// Get reads the value with id from tx.
+func Get[ID any, T any](tx ReadTransaction[T], k Key[ID], id ID) (*T, error) {
+	key, err := k.Encode(id)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Get(key)
+}
+
+// Put writes value with id to tx.
+func Put[ID any, T any](tx WriteTransaction[T], k Key[ID], id ID, value *T) error {
+	key, err := k.Encode(id)
+	if err != nil {
+		return err
+	}
+	return tx.Put(key, value)
+}
+
+// Del deletes the value with id from tx.
+func Del[ID any, T any](tx WriteTransaction[T], k Key[ID], id ID) error {
+	key, err := k.Encode(id)
+	if err != nil {
+		return err
+	}
+	return tx.Del(key)
+}
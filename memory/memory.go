@@ -1,30 +1,30 @@
 package memory
 
 import (
+	"context"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/airheartdev/replicache"
 	"github.com/zyedidia/generic"
 	"github.com/zyedidia/generic/btree"
 )
 
-var ErrNotFound = fmt.Errorf("not found")
+var ErrNotFound = replicache.ErrNotFound
 
 type (
 	MemoryBackend[T any] struct {
-		entries *btree.Tree[string, *Entry[T]]
+		mu      sync.RWMutex
+		entries *btree.Tree[string, *replicache.Entry[T]]
 		spaces  *btree.Tree[string, *Space]
 		clients *btree.Tree[string, *Client]
-	}
-
-	Entry[T any] struct {
-		SpaceID        string
-		Key            string
-		Value          T
-		Deleted        bool
-		Version        uint64
-		LastModifiedAt time.Time
+		views   map[viewKey]map[uint64]replicache.ClientView
+		// viewsUsed holds when the views of each client group were last
+		// used by a pull, so PruneClients can expire them.
+		viewsUsed map[viewKey]time.Time
+		persist   *persistence[T]
 	}
 
 	Client struct {
@@ -34,44 +34,51 @@ type (
 	}
 
 	Space struct {
-		ID             string
-		Version        uint64
+		ID      string
+		Version uint64
+		// MinCookie is the newest version of a purged tombstone. Clients
+		// with an older cookie may have missed the delete.
+		MinCookie      uint64
 		LastModifiedAt time.Time
 	}
 )
 
+var (
+	_ replicache.Store[any] = &MemoryBackend[any]{}
+	_ replicache.AdminStore = &MemoryBackend[any]{}
+)
+
 func New[T any]() *MemoryBackend[T] {
 	return &MemoryBackend[T]{
-		entries: btree.New[string, *Entry[T]](generic.Less[string]),
+		entries: btree.New[string, *replicache.Entry[T]](generic.Less[string]),
 		spaces:  btree.New[string, *Space](generic.Less[string]),
 		clients: btree.New[string, *Client](generic.Less[string]),
 	}
 }
 
+// PutEntry stores a copy of value, so later changes to value by the caller
+// don't affect the stored entry.
 func (t *MemoryBackend[T]) PutEntry(spaceID string, key string, value T, version uint64) error {
-	id := makeKey(spaceID, key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if entry, ok := t.entries.Get(id); ok {
-		entry.LastModifiedAt = time.Now()
-		entry.Version = version
-		entry.Value = value
-		entry.Deleted = false
-		t.entries.Put(id, entry)
-		return nil
-	}
-
-	t.entries.Put(id, &Entry[T]{
-		SpaceID:        spaceID,
-		Key:            key,
-		Value:          value,
-		Deleted:        false,
-		Version:        version,
-		LastModifiedAt: time.Now(),
+	return t.write(change[T]{
+		SpaceID: spaceID,
+		Entries: []replicache.Entry[T]{{
+			SpaceID:        spaceID,
+			Key:            key,
+			Value:          value,
+			Version:        version,
+			LastModifiedAt: time.Now(),
+		}},
 	})
-	return nil
 }
 
+// GetEntry returns a copy of the stored value.
 func (t *MemoryBackend[T]) GetEntry(spaceID string, key string) (*T, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	entry, ok := t.entries.Get(makeKey(spaceID, key))
 	if !ok {
 		return nil, ErrNotFound
@@ -81,97 +88,377 @@ func (t *MemoryBackend[T]) GetEntry(spaceID string, key string) (*T, error) {
 		return nil, ErrNotFound
 	}
 
-	return &entry.Value, nil
+	return replicache.ClonePtr(&entry.Value), nil
 }
 
 func (t *MemoryBackend[T]) DelEntry(spaceID string, key string, version uint64) error {
-	entry, ok := t.entries.Get(makeKey(spaceID, key))
-	if !ok {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.entries.Get(makeKey(spaceID, key)); !ok {
 		return ErrNotFound
 	}
 
-	entry.Deleted = true
-	entry.LastModifiedAt = time.Now()
-	entry.Version = version
-	t.entries.Put(makeKey(spaceID, key), entry)
-	return nil
+	return t.write(change[T]{
+		SpaceID: spaceID,
+		Entries: []replicache.Entry[T]{{
+			SpaceID:        spaceID,
+			Key:            key,
+			Deleted:        true,
+			Version:        version,
+			LastModifiedAt: time.Now(),
+		}},
+	})
 }
 
-func (t *MemoryBackend[T]) GetEntries(spaceID string, fromKey string) []*Entry[T] {
-	entries := make([]*Entry[T], 0)
+func (t *MemoryBackend[T]) GetEntries(spaceID string, fromKey string) ([]*replicache.Entry[T], error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 
-	t.entries.Each(func(key string, val *Entry[T]) {
+	entries := make([]*replicache.Entry[T], 0)
+
+	t.entries.Each(func(key string, val *replicache.Entry[T]) {
 		if val.Key >= fromKey && spaceID == val.SpaceID && !val.Deleted {
-			entries = append(entries, val)
+			entries = append(entries, val.Clone())
 		}
 	})
 
 	sort.Slice(entries, func(i, j int) bool {
 		return entries[i].Key < entries[j].Key
 	})
-	return entries
+	return entries, nil
 }
 
-func (t *MemoryBackend[T]) GetCookie(spaceID string) (uint64, bool) {
+func (t *MemoryBackend[T]) GetCookie(spaceID string) (uint64, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	space, ok := t.spaces.Get(spaceID)
 	if !ok {
-		return 0, false
+		return 0, nil
 	}
-	return space.Version, true
+	return space.Version, nil
 }
 
-func (t *MemoryBackend[T]) SetCookie(spaceID string, version uint64) {
-	if space, ok := t.spaces.Get(spaceID); ok {
-		space.LastModifiedAt = time.Now()
-		space.Version = version
-		t.spaces.Put(spaceID, space)
-		return
-	}
+func (t *MemoryBackend[T]) SetCookie(spaceID string, version uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	t.spaces.Put(spaceID, &Space{
-		ID:             spaceID,
-		Version:        version,
-		LastModifiedAt: time.Now(),
-	})
+	return t.write(change[T]{SpaceID: spaceID, Cookie: &version, At: time.Now()})
 }
 
-func (t *MemoryBackend[T]) GetLastMutationID(clientID string) (uint64, bool) {
+func (t *MemoryBackend[T]) GetLastMutationID(clientID string) (uint64, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	client, ok := t.clients.Get(clientID)
 	if !ok {
-		return 0, false
+		return 0, false, nil
 	}
-	return client.LastMutationID, true
+	return client.LastMutationID, true, nil
 }
 
-func (t *MemoryBackend[T]) SetLastMutationID(clientID string, lastMutationID uint64) {
-	client, ok := t.clients.Get(clientID)
-	if !ok {
-		t.clients.Put(clientID, &Client{
-			ID:             clientID,
-			LastMutationID: lastMutationID,
-			LastModifiedAt: time.Now(),
-		})
-		return
-	}
-	client.LastMutationID = lastMutationID
-	client.LastModifiedAt = time.Now()
-	t.clients.Put(clientID, client)
+func (t *MemoryBackend[T]) SetLastMutationID(clientID string, lastMutationID uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.write(change[T]{ClientID: clientID, LastMutationID: &lastMutationID, At: time.Now()})
 }
 
-func (t *MemoryBackend[T]) GetChangedEntries(spaceID string, prevVersion uint64) []*Entry[T] {
-	entries := make([]*Entry[T], 0)
-	t.entries.Each(func(key string, val *Entry[T]) {
+func (t *MemoryBackend[T]) GetChangedEntries(spaceID string, prevVersion uint64) ([]*replicache.Entry[T], error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entries := make([]*replicache.Entry[T], 0)
+	t.entries.Each(func(key string, val *replicache.Entry[T]) {
 		if val.SpaceID == spaceID && val.Version > prevVersion {
-			entries = append(entries, val)
+			entries = append(entries, val.Clone())
 		}
 	})
-	return entries
+	return entries, nil
+}
+
+// Commit applies cs under a single lock, and as a single record in the
+// write-ahead log of a persistent backend.
+func (t *MemoryBackend[T]) Commit(cs replicache.ChangeSet[T]) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := change[T]{
+		SpaceID: cs.SpaceID,
+		Cookie:  &cs.Version,
+		Entries: cs.Entries,
+		At:      time.Now(),
+	}
+	if cs.ClientID != "" {
+		c.ClientID = cs.ClientID
+		c.LastMutationID = &cs.LastMutationID
+	}
+	return t.write(c)
+}
+
+// Transaction returns a transaction which buffers writes to spaceID until it
+// is flushed.
+func (t *MemoryBackend[T]) Transaction(spaceID string, clientID string, version uint64) replicache.ReadWriteTransaction[T] {
+	return ReplicacheTransaction[T](t, spaceID, clientID, version)
 }
 
 func (t *MemoryBackend[T]) Size() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	return t.entries.Size()
 }
 
+// GetSpaces returns a copy of every space in ID order.
+func (t *MemoryBackend[T]) GetSpaces() []Space {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	spaces := make([]Space, 0, t.spaces.Size())
+	t.spaces.Each(func(key string, val *Space) {
+		spaces = append(spaces, *val)
+	})
+	return spaces
+}
+
+// GetClients returns a copy of every client in ID order.
+func (t *MemoryBackend[T]) GetClients() []Client {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	clients := make([]Client, 0, t.clients.Size())
+	t.clients.Each(func(key string, val *Client) {
+		clients = append(clients, *val)
+	})
+	return clients
+}
+
+// ListSpaces implements replicache.AdminStore.
+func (t *MemoryBackend[T]) ListSpaces() ([]replicache.SpaceInfo, error) {
+	spaces := make([]replicache.SpaceInfo, 0)
+	for _, space := range t.GetSpaces() {
+		spaces = append(spaces, replicache.SpaceInfo{ID: space.ID, Version: space.Version, MinCookie: space.MinCookie})
+	}
+	return spaces, nil
+}
+
+// ListClients implements replicache.AdminStore.
+func (t *MemoryBackend[T]) ListClients() ([]replicache.ClientInfo, error) {
+	clients := make([]replicache.ClientInfo, 0)
+	for _, client := range t.GetClients() {
+		clients = append(clients, replicache.ClientInfo(client))
+	}
+	return clients, nil
+}
+
+// ResetSpace implements replicache.AdminStore.
+func (t *MemoryBackend[T]) ResetSpace(spaceID string, version uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.write(change[T]{
+		SpaceID:   spaceID,
+		Cookie:    &version,
+		MinCookie: &version,
+		At:        time.Now(),
+	}); err != nil {
+		return err
+	}
+	t.deleteViews(func(key viewKey) bool { return key.spaceID == spaceID })
+	return nil
+}
+
+// DeleteClient implements replicache.AdminStore.
+func (t *MemoryBackend[T]) DeleteClient(clientID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deleteViews(func(key viewKey) bool { return key.clientGroupID == clientID })
+	if _, ok := t.clients.Get(clientID); !ok {
+		return nil
+	}
+
+	clients := btree.New[string, *Client](generic.Less[string])
+	t.clients.Each(func(key string, val *Client) {
+		if key != clientID {
+			clients.Put(key, val)
+		}
+	})
+	t.clients = clients
+	return t.compacted(1)
+}
+
+// GetMinCookie returns the oldest cookie from which spaceID can be pulled
+// incrementally. Pulls from an older cookie must be reset.
+func (t *MemoryBackend[T]) GetMinCookie(spaceID string) (uint64, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	space, ok := t.spaces.Get(spaceID)
+	if !ok {
+		return 0, nil
+	}
+	return space.MinCookie, nil
+}
+
+// Compact purges tombstones which were deleted more than retention ago and
+// returns the number purged. The minimum cookie of each affected space is
+// raised to the newest purged version.
+func (t *MemoryBackend[T]) Compact(retention time.Duration) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+	entries := btree.New[string, *replicache.Entry[T]](generic.Less[string])
+	purged := 0
+
+	t.entries.Each(func(key string, val *replicache.Entry[T]) {
+		if !val.Deleted || !val.LastModifiedAt.Before(cutoff) {
+			entries.Put(key, val)
+			return
+		}
+
+		purged++
+		space, ok := t.spaces.Get(val.SpaceID)
+		if !ok {
+			space = &Space{ID: val.SpaceID, LastModifiedAt: time.Now()}
+			t.spaces.Put(val.SpaceID, space)
+		}
+		if val.Version > space.MinCookie {
+			space.MinCookie = val.Version
+		}
+	})
+
+	// Rebuild the tree rather than removing keys, as btree.Remove leaves its
+	// own tombstones behind.
+	t.entries = entries
+	return purged, t.compacted(purged)
+}
+
+// PruneClients forgets clients which haven't pushed for longer than
+// olderThan and returns the number pruned. A pruned client which returns is
+// told its state was not found, so it resets. Client views go with their
+// client, and those unused by a pull for longer than olderThan are dropped
+// too, as clients which only pull have no state to prune.
+func (t *MemoryBackend[T]) PruneClients(olderThan time.Duration) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	clients := btree.New[string, *Client](generic.Less[string])
+	pruned := make(map[string]bool)
+
+	t.clients.Each(func(key string, val *Client) {
+		if val.LastModifiedAt.Before(cutoff) {
+			pruned[key] = true
+			return
+		}
+		clients.Put(key, val)
+	})
+	t.deleteViews(func(key viewKey) bool {
+		return pruned[key.clientGroupID] || t.viewsUsed[key].Before(cutoff)
+	})
+
+	t.clients = clients
+	return len(pruned), t.compacted(len(pruned))
+}
+
+// SweepClients calls PruneClients with ttl every interval until ctx is done,
+// or until pruning fails and the error is returned.
+func (t *MemoryBackend[T]) SweepClients(ctx context.Context, ttl time.Duration, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := t.PruneClients(ttl); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// change is a single write to the backend, and a single record in the
+// write-ahead log.
+type change[T any] struct {
+	SpaceID        string
+	ClientID       string
+	Cookie         *uint64
+	MinCookie      *uint64
+	LastMutationID *uint64
+	Entries        []replicache.Entry[T]
+	At             time.Time
+}
+
+// write logs c if the backend is persistent, then applies it. t.mu must be
+// held.
+func (t *MemoryBackend[T]) write(c change[T]) error {
+	if t.persist != nil {
+		if err := t.persist.append(c); err != nil {
+			return err
+		}
+	}
+
+	t.apply(c)
+
+	// The write is durable once logged, but a failed snapshot is still
+	// reported, as every later write will fail with it.
+	if t.persist != nil && t.persist.snapshotDue() {
+		if err := t.persist.snapshot(t); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *MemoryBackend[T]) apply(c change[T]) {
+	for _, e := range c.Entries {
+		id := makeKey(e.SpaceID, e.Key)
+		entry, ok := t.entries.Get(id)
+		if !ok {
+			if e.Deleted {
+				continue
+			}
+			entry = &replicache.Entry[T]{SpaceID: e.SpaceID, Key: e.Key}
+		}
+
+		entry.LastModifiedAt = e.LastModifiedAt
+		entry.Version = e.Version
+		entry.Deleted = e.Deleted
+		if !e.Deleted {
+			entry.Value = replicache.Clone(e.Value)
+		}
+		t.entries.Put(id, entry)
+	}
+
+	if c.Cookie != nil {
+		space, ok := t.spaces.Get(c.SpaceID)
+		if !ok {
+			space = &Space{ID: c.SpaceID}
+			t.spaces.Put(c.SpaceID, space)
+		}
+		space.Version = *c.Cookie
+		space.LastModifiedAt = c.At
+		if c.MinCookie != nil {
+			space.MinCookie = *c.MinCookie
+		}
+	}
+
+	if c.LastMutationID != nil {
+		client, ok := t.clients.Get(c.ClientID)
+		if !ok {
+			client = &Client{ID: c.ClientID}
+			t.clients.Put(c.ClientID, client)
+		}
+		client.LastMutationID = *c.LastMutationID
+		client.LastModifiedAt = c.At
+	}
+}
+
 func makeKey(spaceID string, key string) string {
 	return spaceID + ":" + key
 }
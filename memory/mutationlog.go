@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/airheartdev/replicache"
+)
+
+// MutationLog is a replicache.MutationLog held in memory.
+type MutationLog struct {
+	mu sync.RWMutex
+	// records is kept in timestamp order, as concurrent pushes append their
+	// records after releasing the push lock, in no particular order.
+	records []replicache.MutationRecord
+}
+
+var _ replicache.MutationLog = &MutationLog{}
+
+func NewMutationLog() *MutationLog {
+	return &MutationLog{}
+}
+
+func (l *MutationLog) Append(ctx context.Context, records ...replicache.MutationRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, rec := range records {
+		// After any records with the same timestamp, so those of a push keep
+		// their order.
+		i := sort.Search(len(l.records), func(i int) bool { return l.records[i].Timestamp.After(rec.Timestamp) })
+		l.records = append(l.records, replicache.MutationRecord{})
+		copy(l.records[i+1:], l.records[i:])
+		l.records[i] = rec
+	}
+	return nil
+}
+
+func (l *MutationLog) Query(ctx context.Context, q replicache.MutationQuery) ([]replicache.MutationRecord, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	records := make([]replicache.MutationRecord, 0)
+	for _, rec := range l.records {
+		if q.Limit > 0 && len(records) == q.Limit {
+			break
+		}
+		if q.Match(rec) {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
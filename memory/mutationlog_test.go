@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMutationLog(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	log := NewMutationLog()
+	log.Append(ctx,
+		replicache.MutationRecord{SpaceID: "space-1", ClientID: "client-1", MutationID: 1, Timestamp: now},
+		replicache.MutationRecord{SpaceID: "space-2", ClientID: "client-2", MutationID: 1, Timestamp: now.Add(time.Second)},
+		replicache.MutationRecord{SpaceID: "space-1", ClientID: "client-1", MutationID: 2, Timestamp: now.Add(2 * time.Second)},
+	)
+
+	records, err := replicache.MutationsBySpace(ctx, log, "space-1")
+	a.NoError(err)
+	a.Len(records, 2)
+
+	records, _ = replicache.MutationsByClient(ctx, log, "client-2")
+	a.Len(records, 1)
+
+	records, _ = replicache.MutationsBetween(ctx, log, now.Add(time.Second), now.Add(2*time.Second))
+	a.Len(records, 1)
+	a.Equal("space-2", records[0].SpaceID)
+
+	records, _ = log.Query(ctx, replicache.MutationQuery{Limit: 2})
+	a.Len(records, 2)
+}
+
+func TestMutationLogOrder(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// A later push may append its records first.
+	log := NewMutationLog()
+	log.Append(ctx,
+		replicache.MutationRecord{ClientID: "client-2", MutationID: 1, Timestamp: now.Add(time.Second)},
+		replicache.MutationRecord{ClientID: "client-2", MutationID: 2, Timestamp: now.Add(time.Second)},
+	)
+	log.Append(ctx,
+		replicache.MutationRecord{ClientID: "client-1", MutationID: 1, Timestamp: now},
+		replicache.MutationRecord{ClientID: "client-1", MutationID: 2, Timestamp: now.Add(2 * time.Second)},
+	)
+
+	records, err := log.Query(ctx, replicache.MutationQuery{})
+	a.NoError(err)
+	var order []string
+	for _, rec := range records {
+		order = append(order, fmt.Sprintf("%s/%d", rec.ClientID, rec.MutationID))
+	}
+	a.Equal([]string{"client-1/1", "client-2/1", "client-2/2", "client-1/2"}, order)
+}
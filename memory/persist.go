@@ -0,0 +1,518 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/airheartdev/replicache"
+)
+
+// ErrClosed is returned by writes to a persistent backend after Close, which
+// could no longer be persisted.
+var ErrClosed = errors.New("backend closed")
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+)
+
+type (
+	// SyncPolicy controls when the write-ahead log is fsynced.
+	SyncPolicy int
+
+	PersistOption func(o *persistOptions)
+
+	persistOptions struct {
+		codec         any
+		sync          SyncPolicy
+		syncInterval  time.Duration
+		snapshotEvery int
+	}
+
+	persistence[T any] struct {
+		dir     string
+		codec   replicache.Codec[T]
+		options persistOptions
+		wal     *os.File
+		records int
+		// err is the first write error, or ErrClosed once closed. Once set,
+		// every later write fails with it, as the log no longer matches
+		// memory.
+		err    error
+		closed bool
+		stop   chan struct{}
+		wg     sync.WaitGroup
+	}
+
+	walRecord struct {
+		SpaceID        string     `json:"spaceID,omitempty"`
+		ClientID       string     `json:"clientID,omitempty"`
+		Cookie         *uint64    `json:"cookie,omitempty"`
+		MinCookie      *uint64    `json:"minCookie,omitempty"`
+		LastMutationID *uint64    `json:"lastMutationID,omitempty"`
+		Entries        []walEntry `json:"entries,omitempty"`
+		At             time.Time  `json:"at"`
+	}
+
+	walEntry struct {
+		SpaceID        string    `json:"spaceID"`
+		Key            string    `json:"key"`
+		Value          []byte    `json:"value,omitempty"`
+		Deleted        bool      `json:"deleted,omitempty"`
+		Version        uint64    `json:"version"`
+		LastModifiedAt time.Time `json:"lastModifiedAt"`
+	}
+
+	snapshot struct {
+		Entries []walEntry `json:"entries"`
+		Spaces  []*Space   `json:"spaces"`
+		Clients []*Client  `json:"clients"`
+	}
+)
+
+const (
+	// SyncAlways fsyncs after every write. This is the default.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs periodically, see WithSyncInterval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// WithCodec sets the Codec used to encode values in snapshots and the
+// write-ahead log. It must be a Codec for the value type of the backend.
+// Defaults to replicache.JSONCodec.
+func WithCodec[T any](codec replicache.Codec[T]) PersistOption {
+	return func(o *persistOptions) {
+		o.codec = codec
+	}
+}
+
+func WithSyncPolicy(policy SyncPolicy) PersistOption {
+	return func(o *persistOptions) {
+		o.sync = policy
+	}
+}
+
+// WithSyncInterval fsyncs the write-ahead log every interval, so at most
+// interval of committed writes is lost on a crash.
+func WithSyncInterval(interval time.Duration) PersistOption {
+	return func(o *persistOptions) {
+		o.sync = SyncInterval
+		o.syncInterval = interval
+	}
+}
+
+// WithSnapshotEvery takes a snapshot and truncates the write-ahead log after
+// n writes. Defaults to 1000. Zero disables automatic snapshots.
+func WithSnapshotEvery(n int) PersistOption {
+	return func(o *persistOptions) {
+		o.snapshotEvery = n
+	}
+}
+
+// Load returns a MemoryBackend holding the state persisted to dir by Open,
+// without writing to dir. Writes to the backend are not persisted.
+func Load[T any](dir string, options ...PersistOption) (*MemoryBackend[T], error) {
+	opts := persistOptions{codec: replicache.JSONCodec[T]{}}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	codec, ok := opts.codec.(replicache.Codec[T])
+	if !ok {
+		return nil, fmt.Errorf("codec %T does not encode %T", opts.codec, *new(T))
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	t := New[T]()
+	p := &persistence[T]{dir: dir, codec: codec, options: opts}
+	if err := p.recover(t, true); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Open returns a MemoryBackend persisted to dir. Its state is recovered from
+// the last snapshot and the write-ahead log of every write since, and every
+// subsequent write is appended to the log before it is applied.
+func Open[T any](dir string, options ...PersistOption) (*MemoryBackend[T], error) {
+	opts := persistOptions{
+		codec:         replicache.JSONCodec[T]{},
+		sync:          SyncAlways,
+		syncInterval:  time.Second,
+		snapshotEvery: 1000,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	codec, ok := opts.codec.(replicache.Codec[T])
+	if !ok {
+		return nil, fmt.Errorf("codec %T does not encode %T", opts.codec, *new(T))
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	t := New[T]()
+	p := &persistence[T]{
+		dir:     dir,
+		codec:   codec,
+		options: opts,
+		stop:    make(chan struct{}),
+	}
+
+	if err := p.recover(t, false); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	p.wal = wal
+	t.persist = p
+
+	if opts.sync == SyncInterval {
+		p.wg.Add(1)
+		go p.syncEvery(opts.syncInterval)
+	}
+
+	return t, nil
+}
+
+// Snapshot writes the full state of a persistent backend and truncates its
+// write-ahead log.
+func (t *MemoryBackend[T]) Snapshot() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.persist == nil {
+		return nil
+	}
+	return t.persist.snapshot(t)
+}
+
+// Close flushes and closes the write-ahead log of a persistent backend.
+// Later writes fail with ErrClosed, while reads still see its state.
+func (t *MemoryBackend[T]) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.persist == nil || t.persist.closed {
+		return nil
+	}
+
+	p := t.persist
+	p.closed = true
+	close(p.stop)
+	p.wg.Wait()
+
+	err := p.wal.Sync()
+	if cerr := p.wal.Close(); err == nil {
+		err = cerr
+	}
+	if p.err != nil {
+		err = p.err
+	}
+	p.err = ErrClosed
+	return err
+}
+
+// compacted snapshots a persistent backend after entries or clients were
+// purged, as purges are not logged.
+func (t *MemoryBackend[T]) compacted(n int) error {
+	if t.persist == nil || n == 0 {
+		return nil
+	}
+	return t.persist.snapshot(t)
+}
+
+// append logs c.
+func (p *persistence[T]) append(c change[T]) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	record := walRecord{
+		SpaceID:        c.SpaceID,
+		ClientID:       c.ClientID,
+		Cookie:         c.Cookie,
+		MinCookie:      c.MinCookie,
+		LastMutationID: c.LastMutationID,
+		At:             c.At,
+	}
+	for _, e := range c.Entries {
+		we, err := p.encodeEntry(&e)
+		if err != nil {
+			return err
+		}
+		record.Entries = append(record.Entries, we)
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.wal.Write(append(line, '\n')); err != nil {
+		p.err = err
+		return err
+	}
+
+	if p.options.sync == SyncAlways {
+		if err := p.wal.Sync(); err != nil {
+			p.err = err
+			return err
+		}
+	}
+
+	p.records++
+	return nil
+}
+
+// snapshotDue reports whether enough records were appended since the last
+// snapshot.
+func (p *persistence[T]) snapshotDue() bool {
+	return p.options.snapshotEvery > 0 && p.records >= p.options.snapshotEvery
+}
+
+func (p *persistence[T]) snapshot(t *MemoryBackend[T]) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	snap := snapshot{
+		Entries: make([]walEntry, 0, t.entries.Size()),
+		Spaces:  make([]*Space, 0, t.spaces.Size()),
+		Clients: make([]*Client, 0, t.clients.Size()),
+	}
+
+	var err error
+	t.entries.Each(func(key string, val *replicache.Entry[T]) {
+		if err != nil {
+			return
+		}
+		var we walEntry
+		we, err = p.encodeEntry(val)
+		snap.Entries = append(snap.Entries, we)
+	})
+	if err != nil {
+		return err
+	}
+	t.spaces.Each(func(key string, val *Space) {
+		snap.Spaces = append(snap.Spaces, val)
+	})
+	t.clients.Each(func(key string, val *Client) {
+		snap.Clients = append(snap.Clients, val)
+	})
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(p.dir, snapshotFile), data); err != nil {
+		p.err = err
+		return err
+	}
+
+	// The snapshot contains every logged write, so the log can start over.
+	// Replaying records over the snapshot is harmless should we crash before
+	// the truncation, as each record sets rather than increments state.
+	if err := p.wal.Truncate(0); err != nil {
+		p.err = err
+		return err
+	}
+	p.records = 0
+	return nil
+}
+
+// recover loads the snapshot and replays the write-ahead log into t. A torn
+// record at the end of the log, left by a crash during a write, has no
+// trailing newline; it is discarded, and truncated from the log unless
+// readOnly is set. Any other bad record is an error, as the records after it
+// would be lost.
+func (p *persistence[T]) recover(t *MemoryBackend[T], readOnly bool) error {
+	data, err := os.ReadFile(filepath.Join(p.dir, snapshotFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		snap := snapshot{}
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return fmt.Errorf("reading snapshot: %w", err)
+		}
+		for _, we := range snap.Entries {
+			entry, err := p.decodeEntry(we)
+			if err != nil {
+				return err
+			}
+			t.entries.Put(makeKey(entry.SpaceID, entry.Key), &entry)
+		}
+		for _, space := range snap.Spaces {
+			t.spaces.Put(space.ID, space)
+		}
+		for _, client := range snap.Clients {
+			t.clients.Put(client.ID, client)
+		}
+	}
+
+	flag := os.O_CREATE | os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(filepath.Join(p.dir, walFile), flag, 0o644)
+	if readOnly && errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		c, err := p.decodeRecord(line)
+		if err != nil {
+			return fmt.Errorf("reading write-ahead log at offset %d: %w", offset, err)
+		}
+
+		t.apply(c)
+		offset += int64(len(line))
+		p.records++
+	}
+
+	if readOnly {
+		return nil
+	}
+	return f.Truncate(offset)
+}
+
+func (p *persistence[T]) decodeRecord(line []byte) (change[T], error) {
+	record := walRecord{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return change[T]{}, err
+	}
+
+	c := change[T]{
+		SpaceID:        record.SpaceID,
+		ClientID:       record.ClientID,
+		Cookie:         record.Cookie,
+		MinCookie:      record.MinCookie,
+		LastMutationID: record.LastMutationID,
+		At:             record.At,
+	}
+	for _, we := range record.Entries {
+		entry, err := p.decodeEntry(we)
+		if err != nil {
+			return change[T]{}, err
+		}
+		c.Entries = append(c.Entries, entry)
+	}
+	return c, nil
+}
+
+func (p *persistence[T]) encodeEntry(e *replicache.Entry[T]) (walEntry, error) {
+	we := walEntry{
+		SpaceID:        e.SpaceID,
+		Key:            e.Key,
+		Deleted:        e.Deleted,
+		Version:        e.Version,
+		LastModifiedAt: e.LastModifiedAt,
+	}
+	if !e.Deleted {
+		value, err := p.codec.Marshal(e.Value)
+		if err != nil {
+			return walEntry{}, err
+		}
+		we.Value = value
+	}
+	return we, nil
+}
+
+func (p *persistence[T]) decodeEntry(we walEntry) (replicache.Entry[T], error) {
+	entry := replicache.Entry[T]{
+		SpaceID:        we.SpaceID,
+		Key:            we.Key,
+		Deleted:        we.Deleted,
+		Version:        we.Version,
+		LastModifiedAt: we.LastModifiedAt,
+	}
+	if !we.Deleted {
+		value, err := p.codec.Unmarshal(we.Value)
+		if err != nil {
+			return entry, err
+		}
+		entry.Value = value
+	}
+	return entry, nil
+}
+
+func (p *persistence[T]) syncEvery(interval time.Duration) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.wal.Sync()
+		}
+	}
+}
+
+// writeFileSync atomically replaces name with data.
+func writeFileSync(name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
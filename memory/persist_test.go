@@ -0,0 +1,201 @@
+package memory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commitTodo(backend *MemoryBackend[string], version uint64, key string, value string) error {
+	return backend.Commit(replicache.ChangeSet[string]{
+		SpaceID:        "Space1",
+		ClientID:       "client-1",
+		Version:        version,
+		LastMutationID: version,
+		Entries: []replicache.Entry[string]{
+			{SpaceID: "Space1", Key: key, Value: value, Version: version},
+		},
+	})
+}
+
+func TestPersistRecovery(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	backend, err := Open[string](dir, WithSnapshotEvery(2))
+	require.NoError(t, err)
+
+	a.NoError(commitTodo(backend, 1, "todo/1", "one"))
+	a.NoError(commitTodo(backend, 2, "todo/2", "two"))
+	a.NoError(commitTodo(backend, 3, "todo/3", "three"))
+	a.NoError(backend.DelEntry("Space1", "todo/1", 4))
+	a.NoError(backend.Close())
+
+	_, err = os.Stat(filepath.Join(dir, snapshotFile))
+	a.NoError(err)
+
+	backend, err = Open[string](dir)
+	require.NoError(t, err)
+	defer backend.Close()
+
+	cookie, err := backend.GetCookie("Space1")
+	a.NoError(err)
+	a.Equal(uint64(3), cookie)
+
+	lastMutationID, ok, err := backend.GetLastMutationID("client-1")
+	a.NoError(err)
+	a.True(ok)
+	a.Equal(uint64(3), lastMutationID)
+
+	_, err = backend.GetEntry("Space1", "todo/1")
+	a.ErrorIs(err, ErrNotFound)
+
+	three, err := backend.GetEntry("Space1", "todo/3")
+	a.NoError(err)
+	a.Equal("three", *three)
+
+	changes, err := backend.GetChangedEntries("Space1", 0)
+	a.NoError(err)
+	a.Len(changes, 3)
+}
+
+func TestPersistWriteAfterClose(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	backend, err := Open[string](dir)
+	require.NoError(t, err)
+	a.NoError(commitTodo(backend, 1, "todo/1", "one"))
+	a.NoError(backend.Close())
+	a.NoError(backend.Close())
+
+	// Writes which could no longer be persisted fail rather than only
+	// reaching memory.
+	a.ErrorIs(commitTodo(backend, 2, "todo/2", "two"), ErrClosed)
+	a.ErrorIs(backend.Snapshot(), ErrClosed)
+	_, err = backend.GetEntry("Space1", "todo/2")
+	a.ErrorIs(err, ErrNotFound)
+	one, err := backend.GetEntry("Space1", "todo/1")
+	a.NoError(err)
+	a.Equal("one", *one)
+}
+
+func TestLoad(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	backend, err := Open[string](dir, WithSnapshotEvery(2))
+	require.NoError(t, err)
+	a.NoError(commitTodo(backend, 1, "todo/1", "one"))
+	a.NoError(commitTodo(backend, 2, "todo/2", "two"))
+	a.NoError(commitTodo(backend, 3, "todo/3", "three"))
+	a.NoError(backend.Close())
+
+	wal, err := os.ReadFile(filepath.Join(dir, walFile))
+	require.NoError(t, err)
+
+	loaded, err := Load[string](dir)
+	require.NoError(t, err)
+	a.Equal([]Space{{ID: "Space1", Version: 3, LastModifiedAt: loaded.GetSpaces()[0].LastModifiedAt}}, loaded.GetSpaces())
+	a.Len(loaded.GetClients(), 1)
+	a.Equal(3, loaded.Size())
+
+	a.NoError(loaded.PutEntry("Space1", "todo/4", "four", 4))
+	after, err := os.ReadFile(filepath.Join(dir, walFile))
+	require.NoError(t, err)
+	a.Equal(wal, after)
+
+	_, err = Load[string](filepath.Join(dir, "missing"))
+	a.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestPersistDiscardsTornRecord(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	backend, err := Open[string](dir, WithSyncPolicy(SyncNever))
+	require.NoError(t, err)
+	a.NoError(commitTodo(backend, 1, "todo/1", "one"))
+	a.NoError(backend.Close())
+
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"spaceID":"Space1","cookie":`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	backend, err = Open[string](dir)
+	require.NoError(t, err)
+
+	one, err := backend.GetEntry("Space1", "todo/1")
+	a.NoError(err)
+	a.Equal("one", *one)
+
+	// Writes after recovery are not appended to the torn record.
+	a.NoError(commitTodo(backend, 2, "todo/2", "two"))
+	a.NoError(backend.Close())
+
+	backend, err = Open[string](dir)
+	require.NoError(t, err)
+	defer backend.Close()
+
+	cookie, err := backend.GetCookie("Space1")
+	a.NoError(err)
+	a.Equal(uint64(2), cookie)
+}
+
+func TestPersistRejectsCorruptRecord(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	backend, err := Open[string](dir, WithSyncPolicy(SyncNever))
+	require.NoError(t, err)
+	a.NoError(commitTodo(backend, 1, "todo/1", "one"))
+	a.NoError(backend.Close())
+
+	// A bad record followed by a good one was not torn by a crash, and
+	// skipping it would lose the records after it.
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString("{\"spaceID\":\"Space1\",\"cookie\":\n{\"spaceID\":\"Space1\",\"cookie\":2}\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = Open[string](dir)
+	a.Error(err)
+
+	_, err = Load[string](dir)
+	a.Error(err)
+}
+
+func TestPersistCompaction(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	backend, err := Open[string](dir)
+	require.NoError(t, err)
+	a.NoError(commitTodo(backend, 1, "todo/1", "one"))
+	a.NoError(backend.DelEntry("Space1", "todo/1", 2))
+	purged, err := backend.Compact(0)
+	a.NoError(err)
+	a.Equal(1, purged)
+	a.NoError(backend.Close())
+
+	backend, err = Open[string](dir)
+	require.NoError(t, err)
+	defer backend.Close()
+
+	a.Equal(0, backend.Size())
+	minCookie, err := backend.GetMinCookie("Space1")
+	a.NoError(err)
+	a.Equal(uint64(2), minCookie)
+}
+
+func TestPersistCodecMismatch(t *testing.T) {
+	_, err := Open[int](t.TempDir(), WithCodec[string](replicache.JSONCodec[string]{}))
+	assert.Error(t, err)
+}
@@ -1,7 +1,10 @@
 package memory
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/airheartdev/replicache"
 	multierror "github.com/hashicorp/go-multierror"
@@ -15,10 +18,19 @@ type InMemoryTransaction[T any] struct {
 	clientID string
 	version  uint64
 	backend  replicache.Backend[T]
+	// undo holds the cache value replaced by each write, so writes since a
+	// savepoint can be rolled back.
+	undo []undoRecord[T]
 	// Executor func(WriteTransaction) error
 	mu *sync.Mutex
 }
 
+type undoRecord[T any] struct {
+	key    string
+	prev   replicache.Value[T]
+	cached bool
+}
+
 func ReplicacheTransaction[T any](backend replicache.Backend[T], spaceID string, clientID string, version uint64) replicache.ReadWriteTransaction[T] {
 	return &InMemoryTransaction[T]{
 		backend:  backend,
@@ -30,33 +42,76 @@ func ReplicacheTransaction[T any](backend replicache.Backend[T], spaceID string,
 	}
 }
 
-var _ replicache.WriteTransaction[any] = &InMemoryTransaction[any]{}
+var (
+	_ replicache.WriteTransaction[any] = &InMemoryTransaction[any]{}
+	_ replicache.Savepointer           = &InMemoryTransaction[any]{}
+)
 
+// Put buffers a copy of value until the transaction is flushed.
 func (t *InMemoryTransaction[T]) Put(key string, value *T) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.cache.Put(key, replicache.Value[T]{Value: value, Dirty: true})
+	t.write(key, replicache.Value[T]{Value: replicache.ClonePtr(value), Dirty: true})
 	return nil
 }
 
+// Del buffers a tombstone for key until the transaction is flushed. The key
+// need not have been read first.
 func (t *InMemoryTransaction[T]) Del(key string) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	t.write(key, replicache.Value[T]{Dirty: true, Value: nil})
+	return nil
+}
 
-	_, ok := t.cache.Get(key)
-	if ok {
-		t.cache.Put(key, replicache.Value[T]{Dirty: true, Value: nil})
+// write caches val for key, remembering the value it replaces.
+func (t *InMemoryTransaction[T]) write(key string, val replicache.Value[T]) {
+	prev, cached := t.cache.Get(key)
+	t.undo = append(t.undo, undoRecord[T]{key: key, prev: prev, cached: cached})
+	t.cache.Put(key, val)
+}
+
+// Savepoint marks the writes made so far, so they can be kept when later
+// writes are rolled back. Savepoints are positions in the undo log, so
+// taking one is free.
+func (t *InMemoryTransaction[T]) Savepoint(ctx context.Context) (replicache.Savepoint, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return replicache.Savepoint(len(t.undo)), nil
+}
+
+// RollbackTo undoes the writes made since sp was taken, newest first.
+func (t *InMemoryTransaction[T]) RollbackTo(ctx context.Context, sp replicache.Savepoint) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if sp < 0 || int(sp) > len(t.undo) {
+		return replicache.ErrInvalidSavepoint
+	}
+	for i := len(t.undo) - 1; i >= int(sp); i-- {
+		u := t.undo[i]
+		if u.cached {
+			t.cache.Put(u.key, u.prev)
+		} else {
+			t.cache.Remove(u.key)
+		}
 	}
+	t.undo = t.undo[:sp]
 	return nil
 }
 
+// Get returns a copy of the value, so it must be Put back for changes to be
+// written.
 func (t *InMemoryTransaction[T]) Get(key string) (*T, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	val, ok := t.cache.Get(key)
 	if ok {
-		return val.Value, nil
+		if val.Value == nil {
+			return nil, ErrNotFound
+		}
+		return replicache.ClonePtr(val.Value), nil
 	}
 
 	entry, err := t.backend.GetEntry(t.spaceID, key)
@@ -65,7 +120,7 @@ func (t *InMemoryTransaction[T]) Get(key string) (*T, error) {
 	}
 
 	t.cache.Put(key, replicache.Value[T]{Value: entry, Dirty: false})
-	return entry, nil
+	return replicache.ClonePtr(entry), nil
 }
 
 func (t *InMemoryTransaction[T]) Has(key string) bool {
@@ -81,6 +136,34 @@ func (t *InMemoryTransaction[T]) IsEmpty() bool {
 	return t.cache.Size() == 0
 }
 
+func (t *InMemoryTransaction[T]) Changes() []replicache.Entry[T] {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]replicache.Entry[T], 0)
+	t.cache.Each(func(key string, val replicache.Value[T]) {
+		if !val.Dirty {
+			return
+		}
+
+		entry := replicache.Entry[T]{
+			SpaceID:        t.spaceID,
+			Key:            key,
+			Deleted:        val.Value == nil,
+			Version:        t.version,
+			LastModifiedAt: now,
+		}
+		if val.Value != nil {
+			entry.Value = replicache.Clone(*val.Value)
+		}
+		entries = append(entries, entry)
+	})
+	return entries
+}
+
+// Flush writes the buffered changes to the backend. Deleting a key which
+// doesn't exist is not an error, as when the changes are committed.
 func (t *InMemoryTransaction[T]) Flush() error {
 	backend := t.backend
 	t.mu.Lock()
@@ -94,13 +177,13 @@ func (t *InMemoryTransaction[T]) Flush() error {
 
 		if val.Value == nil {
 			err := backend.DelEntry(t.spaceID, key, t.version)
-			if err != nil {
-				multierror.Append(errs, err)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				errs = multierror.Append(errs, err)
 			}
 		} else {
 			err := backend.PutEntry(t.spaceID, key, *val.Value, t.version)
 			if err != nil {
-				multierror.Append(errs, err)
+				errs = multierror.Append(errs, err)
 			}
 		}
 	})
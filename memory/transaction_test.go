@@ -1,8 +1,12 @@
 package memory
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/airheartdev/replicache"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,10 +41,12 @@ func TestTransaction(t *testing.T) {
 	err = tx.Flush()
 	a.NoError(err)
 
-	entries := backend.GetEntries("Space1", "")
+	entries, err := backend.GetEntries("Space1", "")
+	a.NoError(err)
 	a.Len(entries, 1)
 
-	changes := backend.GetChangedEntries("Space1", 0)
+	changes, err := backend.GetChangedEntries("Space1", 0)
+	a.NoError(err)
 	a.Len(changes, 2)
 
 	if len(changes) == 0 {
@@ -50,3 +56,211 @@ func TestTransaction(t *testing.T) {
 	a.Equal(false, changes[0].Deleted)
 
 }
+
+func TestTransactionDelWithoutGet(t *testing.T) {
+	a := assert.New(t)
+
+	backend := New[string]()
+	a.NoError(backend.PutEntry("Space1", "todo-1", "Hello World", 1))
+
+	tx := ReplicacheTransaction[string](backend, "Space1", "2", 2)
+	a.NoError(tx.Del("todo-1"))
+	changes := tx.Changes()
+	if a.Len(changes, 1) {
+		a.True(changes[0].Deleted)
+	}
+	a.NoError(tx.Flush())
+	_, err := backend.GetEntry("Space1", "todo-1")
+	a.ErrorIs(err, ErrNotFound)
+
+	// A deleted key reads as missing until the transaction is flushed.
+	tx = ReplicacheTransaction[string](backend, "Space1", "2", 3)
+	a.NoError(tx.Del("todo-2"))
+	_, err = tx.Get("todo-2")
+	a.ErrorIs(err, ErrNotFound)
+
+	// Deleting a missing key is not an error, as on commit.
+	a.NoError(tx.Del("todo-3"))
+	a.NoError(tx.Flush())
+
+	// Every failed write is reported.
+	failing := failingBackend[string]{err: errors.New("disk full")}
+	tx = ReplicacheTransaction[string](failing, "Space1", "2", 4)
+	v := "Hello World"
+	a.NoError(tx.Put("todo-4", &v))
+	a.NoError(tx.Put("todo-5", &v))
+	err = tx.Flush()
+	a.ErrorIs(err, failing.err)
+	a.ErrorContains(err, "2 errors occurred")
+}
+
+type failingBackend[T any] struct {
+	err error
+}
+
+func (b failingBackend[T]) GetEntry(spaceID string, key string) (*T, error) {
+	return nil, ErrNotFound
+}
+
+func (b failingBackend[T]) PutEntry(spaceID string, key string, entry T, version uint64) error {
+	return b.err
+}
+
+func (b failingBackend[T]) DelEntry(spaceID string, key string, version uint64) error {
+	return b.err
+}
+
+type tagged struct {
+	Name string
+	Tags []string
+}
+
+func (t tagged) Clone() tagged {
+	t.Tags = append([]string(nil), t.Tags...)
+	return t
+}
+
+func TestTransactionIsolation(t *testing.T) {
+	a := assert.New(t)
+
+	backend := New[tagged]()
+	a.NoError(backend.PutEntry("Space1", "todo/1", tagged{Name: "one", Tags: []string{"a"}}, 1))
+
+	// Values read from the backend are copies.
+	stored, err := backend.GetEntry("Space1", "todo/1")
+	a.NoError(err)
+	stored.Name = "changed"
+	stored.Tags[0] = "changed"
+
+	tx := ReplicacheTransaction[tagged](backend, "Space1", "1", 2)
+
+	// Changes which are never Put are not flushed.
+	v, err := tx.Get("todo/1")
+	a.NoError(err)
+	v.Name = "uncommitted"
+	v.Tags[0] = "uncommitted"
+
+	// Changes after Put are not flushed either.
+	v2 := tagged{Name: "two", Tags: []string{"b"}}
+	a.NoError(tx.Put("todo/2", &v2))
+	v2.Tags[0] = "after put"
+
+	a.NoError(tx.Flush())
+
+	one, err := backend.GetEntry("Space1", "todo/1")
+	a.NoError(err)
+	a.Equal(tagged{Name: "one", Tags: []string{"a"}}, *one)
+
+	two, err := backend.GetEntry("Space1", "todo/2")
+	a.NoError(err)
+	a.Equal(tagged{Name: "two", Tags: []string{"b"}}, *two)
+
+	changes, err := backend.GetChangedEntries("Space1", 0)
+	a.NoError(err)
+	a.Len(changes, 2)
+	changes[0].Value.Tags[0] = "changed"
+	one, _ = backend.GetEntry("Space1", "todo/1")
+	a.Equal("a", one.Tags[0])
+}
+
+func TestCompact(t *testing.T) {
+	a := assert.New(t)
+
+	backend := New[string]()
+	a.NoError(backend.PutEntry("Space1", "todo/1", "one", 1))
+	a.NoError(backend.PutEntry("Space1", "todo/2", "two", 2))
+	a.NoError(backend.DelEntry("Space1", "todo/1", 3))
+	a.NoError(backend.PutEntry("Space2", "todo/1", "one", 1))
+	a.NoError(backend.DelEntry("Space2", "todo/1", 2))
+
+	purged, err := backend.Compact(time.Hour)
+	a.NoError(err)
+	a.Equal(0, purged)
+	changes, err := backend.GetChangedEntries("Space1", 0)
+	a.NoError(err)
+	a.Len(changes, 2)
+
+	purged, err = backend.Compact(0)
+	a.NoError(err)
+	a.Equal(2, purged)
+	a.Equal(1, backend.Size())
+	changes, err = backend.GetChangedEntries("Space1", 0)
+	a.NoError(err)
+	a.Len(changes, 1)
+	minCookie, err := backend.GetMinCookie("Space1")
+	a.NoError(err)
+	a.Equal(uint64(3), minCookie)
+	minCookie, err = backend.GetMinCookie("Space2")
+	a.NoError(err)
+	a.Equal(uint64(2), minCookie)
+}
+
+func TestPruneClients(t *testing.T) {
+	a := assert.New(t)
+
+	backend := New[string]()
+	a.NoError(backend.SetLastMutationID("client-1", 3))
+	a.NoError(backend.SetLastMutationID("client-2", 1))
+	a.NoError(backend.PutClientView("space-1", "client-1", replicache.ClientView{Cookie: 1}))
+	// client-3 only pulls, so has a view but isn't a client.
+	a.NoError(backend.PutClientView("space-1", "client-3", replicache.ClientView{Cookie: 1}))
+
+	pruned, err := backend.PruneClients(time.Hour)
+	a.NoError(err)
+	a.Equal(0, pruned)
+	_, ok, _ := backend.GetClientView("space-1", "client-3", 1)
+	a.True(ok)
+
+	pruned, err = backend.PruneClients(0)
+	a.NoError(err)
+	a.Equal(2, pruned)
+
+	_, ok, err = backend.GetLastMutationID("client-1")
+	a.NoError(err)
+	a.False(ok)
+
+	// The views of pruned clients go with them, and stale views of clients
+	// which only pull expire.
+	_, ok, err = backend.GetClientView("space-1", "client-1", 1)
+	a.NoError(err)
+	a.False(ok)
+	_, ok, _ = backend.GetClientView("space-1", "client-3", 1)
+	a.False(ok)
+}
+
+func TestTransactionSavepoint(t *testing.T) {
+	a := assert.New(t)
+
+	backend := New[string]()
+	backend.PutEntry("Space1", "todo-1", "stored", 1)
+	tx := ReplicacheTransaction[string](backend, "Space1", "client-1", 2).(*InMemoryTransaction[string])
+	ctx := context.Background()
+
+	one, two := "one", "two"
+	a.NoError(tx.Put("todo-1", &one))
+	sp, err := tx.Savepoint(ctx)
+	a.NoError(err)
+
+	a.NoError(tx.Put("todo-1", &two))
+	a.NoError(tx.Put("todo-2", &two))
+	a.NoError(tx.Del("todo-1"))
+	a.NoError(tx.RollbackTo(ctx, sp))
+
+	// Rolling back keeps sp, so it can be rolled back to again.
+	a.NoError(tx.Put("todo-3", &two))
+	a.NoError(tx.RollbackTo(ctx, sp))
+	a.False(tx.Has("todo-3"))
+
+	v, err := tx.Get("todo-1")
+	a.NoError(err)
+	a.Equal("one", *v)
+	a.False(tx.Has("todo-2"))
+
+	changes := tx.Changes()
+	a.Len(changes, 1)
+	a.Equal("todo-1", changes[0].Key)
+
+	a.NoError(tx.RollbackTo(ctx, 0))
+	a.Empty(tx.Changes())
+	a.ErrorIs(tx.RollbackTo(ctx, sp), replicache.ErrInvalidSavepoint)
+}
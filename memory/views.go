@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/airheartdev/replicache"
+)
+
+// viewKey identifies the client view records of a client group in a space.
+type viewKey struct {
+	spaceID       string
+	clientGroupID string
+}
+
+var _ replicache.ClientViewStore = &MemoryBackend[any]{}
+
+// GetClientView implements replicache.ClientViewStore. Views are not
+// persisted, so after Open every client is sent a full snapshot.
+func (t *MemoryBackend[T]) GetClientView(spaceID string, clientGroupID string, cookie uint64) (replicache.ClientView, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	view, ok := t.views[viewKey{spaceID, clientGroupID}][cookie]
+	return view, ok, nil
+}
+
+// GetLatestCookie implements replicache.ClientViewStore.
+func (t *MemoryBackend[T]) GetLatestCookie(spaceID string, clientGroupID string) (uint64, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var latest uint64
+	for cookie := range t.views[viewKey{spaceID, clientGroupID}] {
+		latest = max(latest, cookie)
+	}
+	return latest, nil
+}
+
+// PutClientView implements replicache.ClientViewStore.
+func (t *MemoryBackend[T]) PutClientView(spaceID string, clientGroupID string, view replicache.ClientView) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.views == nil {
+		t.views = make(map[viewKey]map[uint64]replicache.ClientView)
+	}
+	key := viewKey{spaceID, clientGroupID}
+	if t.views[key] == nil {
+		t.views[key] = make(map[uint64]replicache.ClientView)
+	}
+	t.views[key][view.Cookie] = view
+	t.useViews(key)
+	return nil
+}
+
+// PruneClientViews implements replicache.ClientViewStore.
+func (t *MemoryBackend[T]) PruneClientViews(spaceID string, clientGroupID string, cookie uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := viewKey{spaceID, clientGroupID}
+	views, ok := t.views[key]
+	if !ok {
+		return nil
+	}
+	for older := range views {
+		if older < cookie {
+			delete(views, older)
+		}
+	}
+	t.useViews(key)
+	return nil
+}
+
+// useViews records that the views of key were used by a pull, which prunes
+// them on every pull. t.mu must be held.
+func (t *MemoryBackend[T]) useViews(key viewKey) {
+	if t.viewsUsed == nil {
+		t.viewsUsed = make(map[viewKey]time.Time)
+	}
+	t.viewsUsed[key] = time.Now()
+}
+
+// deleteViews drops the views of every client group in every space for
+// which match returns true. t.mu must be held.
+func (t *MemoryBackend[T]) deleteViews(match func(key viewKey) bool) {
+	for key := range t.views {
+		if match(key) {
+			delete(t.views, key)
+			delete(t.viewsUsed, key)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package replicache
+
+import "time"
+
+type (
+	// Metrics receives measurements from push and pull handling.
+	// Implementations must be safe for concurrent use.
+	Metrics interface {
+		// ObservePush is called when a push has been handled.
+		ObservePush(d time.Duration, err error)
+		// ObserveMutation is called for each mutation in a push. The name
+		// is "unknown" for mutations without a registered mutator.
+		ObserveMutation(name string, result MutationResult)
+		// ObservePull is called when a pull has been handled. patchSize is
+		// the number of patch operations, and reset is set when the patch
+		// started with a clear.
+		ObservePull(d time.Duration, patchSize int, reset bool, err error)
+		// AuthFailed is called when a request is rejected by the AuthFn or
+		// the SpaceAuthorizer.
+		AuthFailed(op Operation)
+	}
+
+	// MutationResult is the outcome of processing a mutation.
+	MutationResult string
+
+	nopMetrics struct{}
+)
+
+const (
+	MutationApplied MutationResult = "applied"
+	MutationSkipped MutationResult = "skipped"
+	MutationFailed  MutationResult = "failed"
+)
+
+// WithMetrics sets where measurements are reported. They are discarded by
+// default.
+func WithMetrics(m Metrics) Option {
+	return func(o *Options) {
+		o.metrics = m
+	}
+}
+
+// mutatorLabel bounds the names reported to Metrics to registered mutators,
+// as clients control the name.
+func (r *Replicache[T]) mutatorLabel(name string) string {
+	if _, ok := r.mutators[name]; !ok {
+		return "unknown"
+	}
+	return name
+}
+
+func (nopMetrics) ObservePush(time.Duration, error)            {}
+func (nopMetrics) ObserveMutation(string, MutationResult)      {}
+func (nopMetrics) ObservePull(time.Duration, int, bool, error) {}
+func (nopMetrics) AuthFailed(Operation)                        {}
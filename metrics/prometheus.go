@@ -0,0 +1,179 @@
+// Package metrics implements replicache.Metrics in process, exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/airheartdev/replicache"
+)
+
+// DefaultBuckets are the upper bounds of the latency histograms, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PatchSizeBuckets are the upper bounds of the patch size histogram.
+var PatchSizeBuckets = []float64{0, 1, 10, 100, 1000, 10000}
+
+type (
+	// Prometheus collects metrics from Replicache and serves them to a
+	// Prometheus scraper.
+	Prometheus struct {
+		mu            sync.Mutex
+		pushDuration  *histogram
+		pushErrors    uint64
+		mutations     map[[2]string]uint64
+		pullDuration  *histogram
+		pullPatchSize *histogram
+		pullErrors    uint64
+		pullResets    uint64
+		authFailures  map[replicache.Operation]uint64
+	}
+
+	histogram struct {
+		buckets []float64
+		counts  []uint64
+		sum     float64
+		count   uint64
+	}
+)
+
+var _ replicache.Metrics = &Prometheus{}
+
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		pushDuration:  newHistogram(DefaultBuckets),
+		mutations:     make(map[[2]string]uint64),
+		pullDuration:  newHistogram(DefaultBuckets),
+		pullPatchSize: newHistogram(PatchSizeBuckets),
+		authFailures:  make(map[replicache.Operation]uint64),
+	}
+}
+
+func (p *Prometheus) ObservePush(d time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pushDuration.observe(d.Seconds())
+	if err != nil {
+		p.pushErrors++
+	}
+}
+
+func (p *Prometheus) ObserveMutation(name string, result replicache.MutationResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.mutations[[2]string{name, string(result)}]++
+}
+
+func (p *Prometheus) ObservePull(d time.Duration, patchSize int, reset bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pullDuration.observe(d.Seconds())
+	if err != nil {
+		p.pullErrors++
+		return
+	}
+	p.pullPatchSize.observe(float64(patchSize))
+	if reset {
+		p.pullResets++
+	}
+}
+
+func (p *Prometheus) AuthFailed(op replicache.Operation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.authFailures[op]++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format, so p can be
+// mounted at /metrics.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b := new(strings.Builder)
+
+	p.pushDuration.write(b, "replicache_push_duration_seconds", "Time taken to handle a push.")
+	counter(b, "replicache_push_errors_total", "Pushes which failed.", p.pushErrors)
+
+	header(b, "replicache_mutations_total", "Mutations processed by name and result.", "counter")
+	keys := make([][2]string, 0, len(p.mutations))
+	for k := range p.mutations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+	for _, k := range keys {
+		fmt.Fprintf(b, "replicache_mutations_total{name=%q,result=%q} %d\n", k[0], k[1], p.mutations[k])
+	}
+
+	p.pullDuration.write(b, "replicache_pull_duration_seconds", "Time taken to handle a pull.")
+	p.pullPatchSize.write(b, "replicache_pull_patch_size", "Operations in each pull patch.")
+	counter(b, "replicache_pull_errors_total", "Pulls which failed.", p.pullErrors)
+	counter(b, "replicache_pull_resets_total", "Pulls answered with a full snapshot.", p.pullResets)
+
+	header(b, "replicache_auth_failures_total", "Requests rejected by authentication or authorization.", "counter")
+	ops := make([]string, 0, len(p.authFailures))
+	for op := range p.authFailures {
+		ops = append(ops, string(op))
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fmt.Fprintf(b, "replicache_auth_failures_total{operation=%q} %d\n", op, p.authFailures[replicache.Operation(op)])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(b *strings.Builder, name string, help string) {
+	header(b, name, help, "histogram")
+	for i, bound := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(b, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(b, "%s_count %d\n", name, h.count)
+}
+
+func counter(b *strings.Builder, name string, help string, v uint64) {
+	header(b, name, help, "counter")
+	fmt.Fprintf(b, "%s %d\n", name, v)
+}
+
+func header(b *strings.Builder, name string, help string, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheus(t *testing.T) {
+	a := assert.New(t)
+
+	p := NewPrometheus()
+	p.ObservePush(20*time.Millisecond, nil)
+	p.ObservePush(2*time.Second, errors.New("boom"))
+	p.ObserveMutation("putTodo", replicache.MutationApplied)
+	p.ObserveMutation("putTodo", replicache.MutationApplied)
+	p.ObserveMutation("unknown", replicache.MutationSkipped)
+	p.ObservePull(time.Millisecond, 5, true, nil)
+	p.AuthFailed(replicache.OperationPull)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	a.Contains(w.Header().Get("Content-Type"), "text/plain")
+	a.Contains(body, "# TYPE replicache_push_duration_seconds histogram\n")
+	a.Contains(body, `replicache_push_duration_seconds_bucket{le="0.025"} 1`+"\n")
+	a.Contains(body, `replicache_push_duration_seconds_bucket{le="+Inf"} 2`+"\n")
+	a.Contains(body, "replicache_push_errors_total 1\n")
+	a.Contains(body, `replicache_mutations_total{name="putTodo",result="applied"} 2`+"\n")
+	a.Contains(body, `replicache_mutations_total{name="unknown",result="skipped"} 1`+"\n")
+	a.Contains(body, `replicache_pull_patch_size_bucket{le="10"} 1`+"\n")
+	a.Contains(body, "replicache_pull_resets_total 1\n")
+	a.Contains(body, `replicache_auth_failures_total{operation="pull"} 1`+"\n")
+}
@@ -0,0 +1,55 @@
+package replicache
+
+import "net/http"
+
+// SpaceIDParam is the name of the path parameter Mount uses for the space ID.
+const SpaceIDParam = "spaceID"
+
+// Route is an endpoint registered by Mount. Path uses {spaceID} style
+// wildcards.
+type Route struct {
+	Path    string
+	Handler http.Handler
+}
+
+// Routes returns the push, pull and poke endpoints, each at its default path
+// and with a trailing {spaceID} segment, wrapped with CORS. Push and pull are
+// handled by Push and Pull, so a store must be set.
+func (r *Replicache[T]) Routes() []Route {
+	return r.RoutesWith(r.Push, r.Pull)
+}
+
+// RoutesWith returns the endpoints of Routes, with push and pull handled by
+// push and pull instead of Push and Pull.
+func (r *Replicache[T]) RoutesWith(push PushFunc, pull PullFunc[T]) []Route {
+	handlers := []struct {
+		path    string
+		handler http.Handler
+	}{
+		{DefaultPushEndpoint, r.HandlePush(push)},
+		{DefaultPullEndpoint, r.HandlePull(pull)},
+		{DefaultPokeEndpoint, r.HandlePoke()},
+	}
+
+	routes := make([]Route, 0, len(handlers)*2)
+	for _, h := range handlers {
+		handler := r.CORS(h.handler)
+		routes = append(routes,
+			Route{Path: h.path, Handler: handler},
+			Route{Path: h.path + "/{" + SpaceIDParam + "}", Handler: handler},
+		)
+	}
+	return routes
+}
+
+// Mount registers Routes on mux.
+func (r *Replicache[T]) Mount(mux *http.ServeMux) {
+	MountRoutes(mux, r.Routes())
+}
+
+// MountRoutes registers routes, e.g. from RoutesWith, on mux.
+func MountRoutes(mux *http.ServeMux, routes []Route) {
+	for _, route := range routes {
+		mux.Handle(route.Path, route.Handler)
+	}
+}
@@ -1,9 +1,64 @@
 package replicache
 
+import "time"
+
 type (
 	Backend[T any] interface {
+		// GetEntry returns the live entry at key, or ErrNotFound.
 		GetEntry(spaceID string, key string) (*T, error)
 		PutEntry(spaceID string, key string, entry T, version uint64) error
 		DelEntry(spaceID string, key string, version uint64) error
 	}
+
+	// Store is a Backend which also tracks space versions and client mutation
+	// IDs, allowing Replicache to process push and pull requests itself.
+	Store[T any] interface {
+		Backend[T]
+		// GetCookie returns the version of spaceID, or 0 for a space never
+		// written to.
+		GetCookie(spaceID string) (uint64, error)
+		// GetLastMutationID returns the last mutation ID of clientID, and
+		// false for a client the store doesn't know.
+		GetLastMutationID(clientID string) (uint64, bool, error)
+		GetChangedEntries(spaceID string, prevVersion uint64) ([]*Entry[T], error)
+		// GetEntries returns the live entries of spaceID from fromKey
+		// onwards, in key order.
+		GetEntries(spaceID string, fromKey string) ([]*Entry[T], error)
+		Transaction(spaceID string, clientID string, version uint64) ReadWriteTransaction[T]
+		// Commit atomically applies the entries in cs, sets the last
+		// mutation ID of cs.ClientID and the version of cs.SpaceID.
+		Commit(cs ChangeSet[T]) error
+	}
+
+	// CookieFloor is implemented by stores which purge tombstones. A pull
+	// from a cookie below the minimum cookie of its space may have missed
+	// deletes, so it is answered with a clear and a full snapshot instead.
+	CookieFloor interface {
+		GetMinCookie(spaceID string) (uint64, error)
+	}
+
+	// AdminStore is implemented by stores which support the admin API.
+	AdminStore interface {
+		ListSpaces() ([]SpaceInfo, error)
+		ListClients() ([]ClientInfo, error)
+		// ResetSpace sets the version of spaceID to version, and its
+		// minimum cookie to version, so every client of the space pulls a
+		// full snapshot.
+		ResetSpace(spaceID string, version uint64) error
+		// DeleteClient forgets clientID, so its next push or pull is told
+		// its state was not found.
+		DeleteClient(clientID string) error
+	}
+
+	SpaceInfo struct {
+		ID        string `json:"id"`
+		Version   uint64 `json:"version"`
+		MinCookie uint64 `json:"minCookie,omitempty"`
+	}
+
+	ClientInfo struct {
+		ID             string    `json:"id"`
+		LastMutationID uint64    `json:"lastMutationID"`
+		LastModifiedAt time.Time `json:"lastModifiedAt"`
+	}
 )
@@ -0,0 +1,85 @@
+package replicache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type (
+	// MutationRecord describes a mutation processed by Push.
+	MutationRecord struct {
+		SpaceID    string          `json:"spaceID"`
+		ClientID   string          `json:"clientID"`
+		ProfileID  string          `json:"profileID"`
+		MutationID uint64          `json:"mutationID"`
+		Name       string          `json:"name"`
+		Args       json.RawMessage `json:"args"`
+		Timestamp  time.Time       `json:"timestamp"`
+		// Version is the version of the space the mutation was committed at,
+		// or zero if the push failed.
+		Version uint64 `json:"version"`
+		// Error is empty if the mutation succeeded.
+		Error string `json:"error,omitempty"`
+	}
+
+	// MutationQuery selects records from a MutationLog. Empty fields match
+	// every record.
+	MutationQuery struct {
+		SpaceID  string
+		ClientID string
+		// Since and Until bound the timestamp of the records, inclusive and
+		// exclusive respectively.
+		Since time.Time
+		Until time.Time
+		// Limit caps the number of records returned.
+		Limit int
+	}
+
+	// MutationLog is an audit log of processed mutations. Records are
+	// returned by Query in timestamp order.
+	MutationLog interface {
+		Append(ctx context.Context, records ...MutationRecord) error
+		Query(ctx context.Context, q MutationQuery) ([]MutationRecord, error)
+	}
+)
+
+// WithMutationLog appends a record of every mutation processed by Push to
+// log.
+func WithMutationLog(log MutationLog) Option {
+	return func(o *Options) {
+		o.mutationLog = log
+	}
+}
+
+// Match reports whether rec is selected by q, ignoring q.Limit.
+func (q MutationQuery) Match(rec MutationRecord) bool {
+	if q.SpaceID != "" && rec.SpaceID != q.SpaceID {
+		return false
+	}
+	if q.ClientID != "" && rec.ClientID != q.ClientID {
+		return false
+	}
+	if !q.Since.IsZero() && rec.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !rec.Timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// MutationsBySpace returns the records of spaceID.
+func MutationsBySpace(ctx context.Context, log MutationLog, spaceID string) ([]MutationRecord, error) {
+	return log.Query(ctx, MutationQuery{SpaceID: spaceID})
+}
+
+// MutationsByClient returns the records of clientID.
+func MutationsByClient(ctx context.Context, log MutationLog, clientID string) ([]MutationRecord, error) {
+	return log.Query(ctx, MutationQuery{ClientID: clientID})
+}
+
+// MutationsBetween returns the records timestamped from since until until.
+func MutationsBetween(ctx context.Context, log MutationLog, since, until time.Time) ([]MutationRecord, error) {
+	return log.Query(ctx, MutationQuery{Since: since, Until: until})
+}
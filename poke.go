@@ -0,0 +1,173 @@
+package replicache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const DefaultPokeEndpoint = "/replicache-poke"
+
+// PokeTokenParam and PokeClientIDParam are the query parameters carrying
+// the token and client ID of a poke stream.
+const (
+	PokeTokenParam    = "auth"
+	PokeClientIDParam = "clientID"
+)
+
+type (
+	// Poker notifies the clients of a space that they should pull.
+	Poker interface {
+		Poke(spaceID string)
+	}
+
+	// PokeBroker is an in-process Poker which fans pokes out to the
+	// subscribers of each space.
+	PokeBroker struct {
+		mu          sync.Mutex
+		subscribers map[string]map[chan struct{}]struct{}
+	}
+)
+
+var _ Poker = &PokeBroker{}
+
+func NewPokeBroker() *PokeBroker {
+	return &PokeBroker{
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Subscribe returns a channel which receives a value whenever spaceID is
+// poked, and a function which cancels the subscription. Pokes are coalesced
+// when the subscriber is slow to receive them.
+func (b *PokeBroker) Subscribe(spaceID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[spaceID] == nil {
+		b.subscribers[spaceID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[spaceID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[spaceID], ch)
+		if len(b.subscribers[spaceID]) == 0 {
+			delete(b.subscribers, spaceID)
+		}
+	}
+}
+
+func (b *PokeBroker) Poke(spaceID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[spaceID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// WithPokeQueryToken lets HandlePoke take the token passed to the AuthFn
+// from the PokeTokenParam query parameter of streams without an
+// Authorization header, as EventSource cannot send one. Tokens in URLs end
+// up wherever URLs are logged, such as access logs, proxies and browser
+// history, so prefer short-lived tokens minted for the stream, or a cookie
+// checked by the AuthFn.
+func WithPokeQueryToken() Option {
+	return func(o *Options) {
+		o.pokeQueryToken = true
+	}
+}
+
+// WithPoker sets the Poker which is notified after every successful push.
+// Defaults to a PokeBroker.
+func WithPoker(poker Poker) Option {
+	return func(o *Options) {
+		o.poker = poker
+	}
+}
+
+// HandlePoke streams pokes for the resolved space as server-sent events. It
+// requires the configured Poker to be a PokeBroker.
+//
+// The stream is authorized as a pull from the space, with the token from
+// the Authorization header, or the PokeTokenParam query parameter if
+// WithPokeQueryToken is set. Clients pass their ID in the PokeClientIDParam
+// query parameter. A stream without one is rejected with 400 Bad Request,
+// one from a revoked client with 403 Forbidden, and the open streams of a
+// client are closed when it is revoked.
+func (r *Replicache[T]) HandlePoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		broker, ok := r.options.poker.(*PokeBroker)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		token := req.Header.Get(authorizationHeader)
+		if token == "" && r.options.pokeQueryToken {
+			token = req.URL.Query().Get(PokeTokenParam)
+		}
+		ctx, ok := r.authenticate(req.Context(), w, token, OperationPull)
+		if !ok {
+			return
+		}
+		clientID := req.URL.Query().Get(PokeClientIDParam)
+		if clientID == "" {
+			writeError(ctx, w, http.StatusBadRequest, errors.New(PokeClientIDParam+" query parameter is required"))
+			return
+		}
+
+		spaceID, ok := r.resolveSpace(w, req.WithContext(ctx))
+		if !ok {
+			return
+		}
+		if !r.authorizeSpace(ctx, w, spaceID, OperationPull) {
+			return
+		}
+
+		revoked, unwatch, ok := r.watchRevocation(clientID)
+		if !ok {
+			writeError(ctx, w, http.StatusForbidden, ErrClientRevoked)
+			return
+		}
+		defer unwatch()
+
+		pokes, cancel := broker.Subscribe(spaceID)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-revoked:
+				return
+			case <-pokes:
+				fmt.Fprint(w, "data: poke\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
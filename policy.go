@@ -0,0 +1,184 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"time"
+)
+
+// FailurePolicy decides what Push does when a mutator returns an error.
+// Unknown mutators are always skipped.
+type FailurePolicy struct {
+	retries int
+	skip    bool
+}
+
+var (
+	// AbortOnFailure fails the whole push, so none of its mutations are
+	// applied and the client retries them. This is the default.
+	AbortOnFailure = FailurePolicy{}
+
+	// SkipOnFailure rolls back the writes of the failed mutation, records
+	// its error and marks it processed, so the rest of the push is applied
+	// and the client moves on.
+	SkipOnFailure = FailurePolicy{skip: true}
+)
+
+// RetryThenSkip runs a failed mutator up to retries more times within the
+// push, rolling back its writes before each attempt, and then skips it as
+// SkipOnFailure does.
+func RetryThenSkip(retries int) FailurePolicy {
+	return FailurePolicy{retries: retries, skip: true}
+}
+
+// WithFailurePolicy sets what Push does when a mutator fails. Policies
+// other than AbortOnFailure need a store whose transactions implement
+// Savepointer, and abort otherwise.
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(o *Options) {
+		o.failurePolicy = policy
+	}
+}
+
+// WithMutatorTimeout limits how long each mutator may run. A mutator which
+// times out fails with context.DeadlineExceeded and is handled by the
+// failure policy. Mutators should return once their context is done; one
+// which doesn't is abandoned, and its later transaction calls fail with
+// ErrTransactionClosed.
+func WithMutatorTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.mutatorTimeout = d
+	}
+}
+
+// WithPushTimeout limits how long a push may hold the lock on the store.
+// Mutations not finished by the deadline are left for the client to push
+// again, while those already processed are committed. The mutation running
+// at the deadline is rolled back rather than handled by the failure policy,
+// if the store's transactions implement Savepointer. The first mutation of
+// a push is never left for the next one, so a mutation which alone outlasts
+// the deadline fails with context.DeadlineExceeded and is handled by the
+// failure policy, as one which outlasts the mutator timeout is.
+func WithPushTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.pushTimeout = d
+	}
+}
+
+// applyMutation runs m according to the failure policy. It reports whether
+// a failed mutation was rolled back and skipped, in which case err is the
+// mutator's last error. A mutation which takes its space over quota fails
+// like any other.
+func (r *Replicache[T]) applyMutation(ctx context.Context, tx ReadWriteTransaction[T], quota *pushQuota, m Mutation) (skipped bool, err error) {
+	policy := r.options.failurePolicy
+	sp, ok := savepointerOf(tx)
+	if !policy.skip || !ok {
+		return false, r.mutateWithinQuota(ctx, tx, quota, m)
+	}
+
+	mark, err := sp.Savepoint(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = r.mutateWithinQuota(ctx, tx, quota, m)
+		if err == nil || errors.Is(err, ErrMutatorNotFound) {
+			return false, err
+		}
+
+		if rerr := sp.RollbackTo(ctx, mark); rerr != nil {
+			return false, errors.Join(err, rerr)
+		}
+		if attempt >= policy.retries {
+			return true, err
+		}
+	}
+}
+
+func savepointerOf[T any](tx ReadWriteTransaction[T]) (Savepointer, bool) {
+	if traced, ok := tx.(*tracedTransaction[T]); ok {
+		tx = traced.ReadWriteTransaction
+	}
+	sp, ok := tx.(Savepointer)
+	return sp, ok
+}
+
+// mutateWithinQuota invokes the mutator for m, then checks its writes
+// against quota, if any, which holds the writes of the mutations before it.
+func (r *Replicache[T]) mutateWithinQuota(ctx context.Context, tx ReadWriteTransaction[T], quota *pushQuota, m Mutation) error {
+	if quota == nil {
+		return r.mutate(ctx, tx, m)
+	}
+
+	qtx := &quotaTransaction[T]{ReadWriteTransaction: tx, written: make(map[string]int64)}
+	if err := r.mutate(ctx, qtx, m); err != nil {
+		return err
+	}
+	return quota.add(qtx.written)
+}
+
+// mutate invokes the mutator for m in its own span, within the mutator
+// timeout.
+func (r *Replicache[T]) mutate(ctx context.Context, tx ReadWriteTransaction[T], m Mutation) error {
+	mctx, span := r.options.tracer.Start(ctx, "replicache.mutator")
+	span.SetAttribute("replicache.mutator", r.mutatorLabel(m.Name))
+	span.SetAttribute("replicache.mutation_id", m.ID)
+	withContext(tx, mctx)
+
+	if r.options.mutatorTimeout > 0 {
+		var cancel context.CancelFunc
+		mctx, cancel = context.WithTimeout(mctx, r.options.mutatorTimeout)
+		defer cancel()
+	}
+
+	err := r.mutateWithDeadline(mctx, tx, m)
+	endSpan(span, err)
+	return err
+}
+
+// mutateWithDeadline runs the mutator in its own goroutine when ctx has a
+// deadline, so Push can move on when it passes.
+func (r *Replicache[T]) mutateWithDeadline(ctx context.Context, tx ReadWriteTransaction[T], m Mutation) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return r.safeMutate(ctx, tx, m)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	guard := &guardedTransaction[T]{tx: tx}
+	done := make(chan error, 1)
+	go func() {
+		done <- r.safeMutate(ctx, guard, m)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		guard.close()
+		return ctx.Err()
+	}
+}
+
+// safeMutate recovers a panic in the mutator as a *PanicError.
+func (r *Replicache[T]) safeMutate(ctx context.Context, tx ReadWriteTransaction[T], m Mutation) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return r.Mutate(ctx, tx, m)
+}
+
+// errorAttrs returns the log attributes describing err.
+func errorAttrs(err error) []any {
+	attrs := []any{"error", err}
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		attrs = append(attrs, "stack", string(panicErr.Stack))
+	}
+	return attrs
+}
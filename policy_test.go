@@ -0,0 +1,242 @@
+package replicache_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+)
+
+func (s *SyncSuite) TestSkipOnFailure() {
+	mutations := memory.NewMutationLog()
+	s.setup(replicache.WithFailurePolicy(replicache.SkipOnFailure), replicache.WithMutationLog(mutations))
+	s.rep.Register("putThenFail", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		v := "partial"
+		tx.Put("todo/1", &v)
+		tx.Put("todo/2", &v)
+		return errors.New("boom")
+	})
+
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+		replicache.Mutation{ID: 2, Name: "putThenFail"},
+		replicache.Mutation{ID: 3, Name: "put", Args: json.RawMessage(`{"Key":"todo/3","Value":"three"}`)},
+	)
+
+	lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(3), lastMutationID)
+
+	one, err := s.store.GetEntry("space-1", "todo/1")
+	s.Require().NoError(err)
+	s.Equal("one", *one)
+	_, err = s.store.GetEntry("space-1", "todo/2")
+	s.Error(err)
+	_, err = s.store.GetEntry("space-1", "todo/3")
+	s.NoError(err)
+
+	records, err := replicache.MutationsByClient(context.Background(), mutations, "client-1")
+	s.Require().NoError(err)
+	s.Require().Len(records, 3)
+	s.Equal("boom", records[1].Error)
+	s.Equal(uint64(1), records[1].Version)
+}
+
+func (s *SyncSuite) TestRetryThenSkip() {
+	s.setup(replicache.WithFailurePolicy(replicache.RetryThenSkip(2)))
+
+	attempts := 0
+	s.rep.Register("flaky", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		attempts++
+		v := "flaky"
+		tx.Put("todo/flaky", &v)
+		if attempts < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "flaky"})
+	s.Equal(3, attempts)
+	_, err := s.store.GetEntry("space-1", "todo/flaky")
+	s.NoError(err)
+
+	attempts = -10
+	s.push("space-1", replicache.Mutation{ID: 2, Name: "flaky"})
+	s.Equal(-7, attempts)
+	lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(2), lastMutationID)
+}
+
+func (s *SyncSuite) TestAbortOnFailure() {
+	s.setup(replicache.WithFailurePolicy(replicache.AbortOnFailure))
+	s.rep.Register("fail", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		return errors.New("boom")
+	})
+
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID: "client-1",
+		Mutations: []replicache.Mutation{
+			{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+			{ID: 2, Name: "fail"},
+		},
+	})
+	s.Equal(http.StatusInternalServerError, w.Code)
+	_, known, _ := s.store.GetLastMutationID("client-1")
+	s.False(known)
+}
+
+// withoutSavepoints hides the savepoints of the memory backend's
+// transactions.
+type withoutSavepoints struct {
+	*memory.MemoryBackend[string]
+}
+
+func (w withoutSavepoints) Transaction(spaceID string, clientID string, version uint64) replicache.ReadWriteTransaction[string] {
+	return struct {
+		replicache.ReadWriteTransaction[string]
+	}{w.MemoryBackend.Transaction(spaceID, clientID, version)}
+}
+
+func (s *SyncSuite) TestSkipOnFailureWithoutSavepoints() {
+	s.setup(replicache.WithFailurePolicy(replicache.SkipOnFailure))
+	s.rep.SetStore(withoutSavepoints{s.store})
+	s.rep.Register("fail", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		return errors.New("boom")
+	})
+
+	// The failed mutation can't be rolled back, so the push is aborted.
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID: "client-1",
+		Mutations: []replicache.Mutation{
+			{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+			{ID: 2, Name: "fail"},
+		},
+	})
+	s.Equal(http.StatusInternalServerError, w.Code)
+	_, known, _ := s.store.GetLastMutationID("client-1")
+	s.False(known)
+}
+
+func (s *SyncSuite) TestPanickingMutator() {
+	mutations := memory.NewMutationLog()
+	s.setup(replicache.WithFailurePolicy(replicache.AbortOnFailure), replicache.WithMutationLog(mutations))
+	s.rep.Register("panic", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		panic("oops")
+	})
+
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: []replicache.Mutation{{ID: 1, Name: "panic"}},
+	})
+	s.Equal(http.StatusInternalServerError, w.Code)
+	s.NotContains(w.Body.String(), "oops")
+
+	records, err := replicache.MutationsByClient(context.Background(), mutations, "client-1")
+	s.Require().NoError(err)
+	s.Require().Len(records, 1)
+	s.Equal("mutator panicked: oops", records[0].Error)
+
+	var panicErr *replicache.PanicError
+	err = s.rep.Push(context.Background(), &replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: []replicache.Mutation{{ID: 1, Name: "panic"}},
+	}, "space-1")
+	s.Require().ErrorAs(err, &panicErr)
+	s.Equal("oops", panicErr.Value)
+	s.Contains(string(panicErr.Stack), "TestPanickingMutator")
+}
+
+func (s *SyncSuite) TestMutatorTimeout() {
+	s.setup(replicache.WithFailurePolicy(replicache.SkipOnFailure), replicache.WithMutatorTimeout(10*time.Millisecond))
+
+	abandoned := make(chan error, 1)
+	s.rep.Register("slow", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		// Ignores ctx, as a misbehaving mutator would.
+		time.Sleep(50 * time.Millisecond)
+		v := "late"
+		abandoned <- tx.Put("todo/late", &v)
+		return nil
+	})
+
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "slow"},
+		replicache.Mutation{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/2","Value":"two"}`)},
+	)
+	s.ErrorIs(<-abandoned, replicache.ErrTransactionClosed)
+
+	lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(2), lastMutationID)
+	_, err := s.store.GetEntry("space-1", "todo/late")
+	s.Error(err)
+	_, err = s.store.GetEntry("space-1", "todo/2")
+	s.NoError(err)
+}
+
+func (s *SyncSuite) TestPushTimeout() {
+	for _, policy := range []replicache.FailurePolicy{replicache.AbortOnFailure, replicache.SkipOnFailure} {
+		s.setup(replicache.WithFailurePolicy(policy), replicache.WithPushTimeout(20*time.Millisecond))
+		s.rep.Register("wait", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+			v := "partial"
+			tx.Put("todo/2", &v)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		s.push("space-1",
+			replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+			replicache.Mutation{ID: 2, Name: "wait"},
+			replicache.Mutation{ID: 3, Name: "put", Args: json.RawMessage(`{"Key":"todo/3","Value":"three"}`)},
+		)
+
+		// The mutation cut short is rolled back and left for the next push
+		// with the rest, whatever the failure policy.
+		lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+		s.Equal(uint64(1), lastMutationID)
+		_, err := s.store.GetEntry("space-1", "todo/1")
+		s.NoError(err)
+		_, err = s.store.GetEntry("space-1", "todo/2")
+		s.Error(err)
+		_, err = s.store.GetEntry("space-1", "todo/3")
+		s.Error(err)
+	}
+}
+
+func (s *SyncSuite) TestPushTimeoutFirstMutation() {
+	setup := func(policy replicache.FailurePolicy) {
+		s.setup(replicache.WithFailurePolicy(policy), replicache.WithPushTimeout(20*time.Millisecond))
+		s.rep.Register("wait", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+			v := "partial"
+			tx.Put("todo/1", &v)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}
+	mutations := []replicache.Mutation{
+		{ID: 1, Name: "wait"},
+		{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/2","Value":"two"}`)},
+	}
+
+	// A first mutation which outlasts the deadline is skipped by the
+	// policy, rather than deferred on every push. The rest are deferred.
+	setup(replicache.SkipOnFailure)
+	s.push("space-1", mutations...)
+	lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(1), lastMutationID)
+	_, err := s.store.GetEntry("space-1", "todo/1")
+	s.Error(err)
+
+	s.push("space-1", mutations[1:]...)
+	lastMutationID, _, _ = s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(2), lastMutationID)
+
+	// Aborting fails the push.
+	setup(replicache.AbortOnFailure)
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: mutations,
+	})
+	s.Equal(http.StatusInternalServerError, w.Code)
+}
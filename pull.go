@@ -16,6 +16,12 @@ type (
 		Patch          []PatchOperation[T] `json:"patch"`
 	}
 
+	// ClientStateNotFoundResponse tells a client that the server has no
+	// record of it, so it must reset with a new client ID.
+	ClientStateNotFoundResponse struct {
+		Error string `json:"error"`
+	}
+
 	PatchOperation[T any] struct {
 		Op    PatchOp `json:"op"`
 		Key   *string `json:"key,omitempty"`
@@ -0,0 +1,234 @@
+package replicache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type (
+	// Quota limits the live entries of a space. Zero fields are unlimited.
+	Quota struct {
+		MaxEntries int
+		// MaxBytes limits the total size of the values in the space, as
+		// encoded to JSON.
+		MaxBytes int64
+	}
+
+	// QuotaError describes the quota a commit would have exceeded. It
+	// matches ErrQuotaExceeded with errors.Is.
+	QuotaError struct {
+		SpaceID string
+		// Limit is "entries" or "bytes".
+		Limit string
+		Max   int64
+		Usage int64
+	}
+
+	// spaceUsage tracks the size of each live entry of a space.
+	spaceUsage struct {
+		sizes map[string]int64
+		bytes int64
+	}
+
+	// pushQuota is the quota of a space with the usage of the mutations of
+	// a push added so far, so each mutation is checked against it in turn.
+	pushQuota struct {
+		spaceID string
+		quota   Quota
+		usage   *spaceUsage
+		// sizes holds the size of each entry written by the push, or -1
+		// for those it deleted, and entries and bytes the change they make
+		// to usage.
+		sizes   map[string]int64
+		entries int
+		bytes   int64
+	}
+
+	// quotaTransaction records the size of each entry a mutation writes.
+	quotaTransaction[T any] struct {
+		ReadWriteTransaction[T]
+		written map[string]int64
+	}
+
+	// usageChange is the change a commit makes to the usage of a space:
+	// the new size of each entry it writes, or -1 for those it deletes.
+	usageChange struct {
+		usage *spaceUsage
+		sizes map[string]int64
+	}
+)
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("space %s would hold %d %s, over its quota of %d", e.SpaceID, e.Usage, e.Limit, e.Max)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// WithSpaceQuota enforces the quota returned by fn for each space when
+// changes are committed. A commit which would exceed it fails with a
+// *QuotaError. Pushes check the quota after each mutation, against the usage
+// with the earlier mutations of the push, so a mutation which would exceed
+// it fails with a *QuotaError and is handled by the failure policy. When the
+// push is aborted, it is answered with 507 Insufficient Storage; when the
+// mutation is skipped, the rest of the push is still committed.
+//
+// Usage is counted from the store the first time a space is committed to,
+// then tracked from each commit, so writes which bypass Push and Transact
+// are not counted.
+func WithSpaceQuota(fn func(spaceID string) Quota) Option {
+	return func(o *Options) {
+		o.quota = fn
+	}
+}
+
+// checkQuota returns the change cs makes to the usage of cs.SpaceID, to be
+// applied once cs is committed, or a *QuotaError. It is called with r.mu
+// held.
+func (r *Replicache[T]) checkQuota(cs ChangeSet[T]) (*usageChange, error) {
+	q, err := r.newPushQuota(cs.SpaceID)
+	if q == nil || err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(cs.Entries))
+	for _, entry := range cs.Entries {
+		if entry.Deleted {
+			sizes[entry.Key] = -1
+			continue
+		}
+		size, err := sizeOf(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+		sizes[entry.Key] = size
+	}
+	if err := q.add(sizes); err != nil {
+		return nil, err
+	}
+	return &usageChange{usage: q.usage, sizes: sizes}, nil
+}
+
+// newPushQuota returns the quota of spaceID with its usage, or nil if
+// spaces have no quota. It is called with r.mu held.
+func (r *Replicache[T]) newPushQuota(spaceID string) (*pushQuota, error) {
+	if r.options.quota == nil {
+		return nil, nil
+	}
+
+	usage, ok := r.usage[spaceID]
+	if !ok {
+		entries, err := r.store.GetEntries(spaceID, "")
+		if err != nil {
+			return nil, err
+		}
+		usage = &spaceUsage{sizes: make(map[string]int64)}
+		for _, entry := range entries {
+			size, err := sizeOf(entry.Value)
+			if err != nil {
+				return nil, err
+			}
+			usage.sizes[entry.Key] = size
+			usage.bytes += size
+		}
+		r.usage[spaceID] = usage
+	}
+
+	return &pushQuota{
+		spaceID: spaceID,
+		quota:   r.options.quota(spaceID),
+		usage:   usage,
+		sizes:   make(map[string]int64),
+	}, nil
+}
+
+// add checks the writes of a mutation, the new size of each entry it wrote
+// or -1 for those it deleted, against the quota with the writes added
+// before, and adds them if they are within it. A mutation which doesn't
+// add entries or bytes is always within it.
+func (q *pushQuota) add(written map[string]int64) error {
+	var entries int
+	var bytes int64
+	for key, size := range written {
+		prev, live := q.sizes[key]
+		if !live {
+			prev, live = q.usage.sizes[key]
+		}
+		live = live && prev >= 0
+		switch {
+		case size >= 0 && !live:
+			entries++
+			bytes += size
+		case size >= 0:
+			bytes += size - prev
+		case live:
+			entries--
+			bytes -= prev
+		}
+	}
+
+	if next := len(q.usage.sizes) + q.entries + entries; q.quota.MaxEntries > 0 && next > q.quota.MaxEntries && entries > 0 {
+		return &QuotaError{SpaceID: q.spaceID, Limit: "entries", Max: int64(q.quota.MaxEntries), Usage: int64(next)}
+	}
+	if next := q.usage.bytes + q.bytes + bytes; q.quota.MaxBytes > 0 && next > q.quota.MaxBytes && bytes > 0 {
+		return &QuotaError{SpaceID: q.spaceID, Limit: "bytes", Max: q.quota.MaxBytes, Usage: next}
+	}
+
+	for key, size := range written {
+		q.sizes[key] = size
+	}
+	q.entries += entries
+	q.bytes += bytes
+	return nil
+}
+
+// Put sizes value before writing it, so the quota of the mutation can be
+// checked without encoding the other writes of the push again.
+func (t *quotaTransaction[T]) Put(key string, value *T) error {
+	size := int64(-1)
+	if value != nil {
+		var err error
+		if size, err = sizeOf(*value); err != nil {
+			return err
+		}
+	}
+	if err := t.ReadWriteTransaction.Put(key, value); err != nil {
+		return err
+	}
+	t.written[key] = size
+	return nil
+}
+
+func (t *quotaTransaction[T]) Del(key string) error {
+	if err := t.ReadWriteTransaction.Del(key); err != nil {
+		return err
+	}
+	t.written[key] = -1
+	return nil
+}
+
+// apply records the change in the usage of its space.
+func (c *usageChange) apply() {
+	for key, size := range c.sizes {
+		c.usage.bytes -= c.usage.sizes[key]
+		if size < 0 {
+			delete(c.usage.sizes, key)
+			continue
+		}
+		c.usage.sizes[key] = size
+		c.usage.bytes += size
+	}
+}
+
+// sizeOf returns the size of value encoded to JSON.
+func sizeOf(value any) (int64, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("sizing value for quota: %w", err)
+	}
+	return int64(len(b)), nil
+}
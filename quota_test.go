@@ -0,0 +1,125 @@
+package replicache_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/airheartdev/replicache"
+)
+
+func (s *SyncSuite) TestQuotaMaxEntries() {
+	s.setup(replicache.WithSpaceQuota(func(spaceID string) replicache.Quota {
+		return replicache.Quota{MaxEntries: 2}
+	}))
+
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+		replicache.Mutation{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/2","Value":"two"}`)},
+	)
+
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID: "client-1",
+		Mutations: []replicache.Mutation{
+			{ID: 3, Name: "put", Args: json.RawMessage(`{"Key":"todo/3","Value":"three"}`)},
+		},
+	})
+	s.Equal(http.StatusInsufficientStorage, w.Code)
+
+	lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(2), lastMutationID)
+	_, err := s.store.GetEntry("space-1", "todo/3")
+	s.Error(err)
+
+	// Overwriting and deleting entries is allowed at the limit.
+	err = s.rep.Transact(context.Background(), "space-1", func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "uno"
+		if err := tx.Put("todo/1", &v); err != nil {
+			return err
+		}
+		return tx.Del("todo/2")
+	})
+	s.Require().NoError(err)
+
+	s.push("space-1",
+		replicache.Mutation{ID: 3, Name: "put", Args: json.RawMessage(`{"Key":"todo/3","Value":"three"}`)},
+	)
+}
+
+func (s *SyncSuite) TestQuotaSkipsMutation() {
+	s.setup(
+		replicache.WithFailurePolicy(replicache.SkipOnFailure),
+		replicache.WithSpaceQuota(func(spaceID string) replicache.Quota {
+			return replicache.Quota{MaxEntries: 2}
+		}),
+	)
+
+	// The put over the quota is skipped, while the delete queued after it
+	// frees space for the next put.
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+		replicache.Mutation{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/2","Value":"two"}`)},
+		replicache.Mutation{ID: 3, Name: "put", Args: json.RawMessage(`{"Key":"todo/3","Value":"three"}`)},
+		replicache.Mutation{ID: 4, Name: "del", Args: json.RawMessage(`"todo/1"`)},
+		replicache.Mutation{ID: 5, Name: "put", Args: json.RawMessage(`{"Key":"todo/4","Value":"four"}`)},
+	)
+
+	lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(5), lastMutationID)
+	_, err := s.store.GetEntry("space-1", "todo/3")
+	s.Error(err)
+	_, err = s.store.GetEntry("space-1", "todo/4")
+	s.NoError(err)
+}
+
+func (s *SyncSuite) TestQuotaRewritesWithinPush() {
+	s.setup(replicache.WithSpaceQuota(func(spaceID string) replicache.Quota {
+		return replicache.Quota{MaxEntries: 2}
+	}))
+
+	// Each mutation is checked against the writes of those before it, so
+	// rewriting or deleting an entry written earlier in the push is counted
+	// once.
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+		replicache.Mutation{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/2","Value":"two"}`)},
+		replicache.Mutation{ID: 3, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"uno"}`)},
+		replicache.Mutation{ID: 4, Name: "del", Args: json.RawMessage(`"todo/2"`)},
+		replicache.Mutation{ID: 5, Name: "put", Args: json.RawMessage(`{"Key":"todo/3","Value":"three"}`)},
+	)
+
+	lastMutationID, _, _ := s.store.GetLastMutationID("client-1")
+	s.Equal(uint64(5), lastMutationID)
+	one, err := s.store.GetEntry("space-1", "todo/1")
+	s.Require().NoError(err)
+	s.Equal("uno", *one)
+	_, err = s.store.GetEntry("space-1", "todo/3")
+	s.NoError(err)
+}
+
+func (s *SyncSuite) TestQuotaMaxBytes() {
+	s.setup(replicache.WithSpaceQuota(func(spaceID string) replicache.Quota {
+		if spaceID == "small" {
+			return replicache.Quota{MaxBytes: 10}
+		}
+		return replicache.Quota{}
+	}))
+
+	// Values are counted as JSON, so "12345678" takes 10 bytes.
+	put := func(spaceID, value string) error {
+		return s.rep.Transact(context.Background(), spaceID, func(tx replicache.ReadWriteTransaction[string]) error {
+			return tx.Put("key", &value)
+		})
+	}
+	s.NoError(put("small", "12345678"))
+
+	err := put("small", "123456789")
+	s.True(errors.Is(err, replicache.ErrQuotaExceeded))
+	var quotaErr *replicache.QuotaError
+	s.Require().ErrorAs(err, &quotaErr)
+	s.Equal("bytes", quotaErr.Limit)
+	s.Equal(int64(11), quotaErr.Usage)
+
+	s.NoError(put("large", "123456789"))
+}
@@ -0,0 +1,195 @@
+package replicache
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// rateLimiterIDs numbers the limiters in the order they are created.
+var rateLimiterIDs atomic.Uint64
+
+type (
+	// RateLimitKey returns the key a request is limited by. Requests with
+	// an empty key are not limited.
+	RateLimitKey func(ctx context.Context, op Operation, clientID string, spaceID string) string
+
+	// RateLimiter is a token bucket per key. Each bucket holds up to burst
+	// tokens and refills at rate tokens per second; every push or pull
+	// takes one.
+	RateLimiter struct {
+		// id orders the limiters for locking.
+		id      uint64
+		rate    float64
+		burst   float64
+		key     RateLimitKey
+		now     func() time.Time
+		mu      sync.Mutex
+		buckets map[string]*bucket
+		calls   int
+	}
+
+	bucket struct {
+		tokens float64
+		at     time.Time
+	}
+)
+
+// ByClient limits each client.
+func ByClient(ctx context.Context, op Operation, clientID string, spaceID string) string {
+	return clientID
+}
+
+// BySpace limits each space.
+func BySpace(ctx context.Context, op Operation, clientID string, spaceID string) string {
+	return spaceID
+}
+
+// ByPrincipal limits each principal returned by the AuthFn.
+func ByPrincipal(ctx context.Context, op Operation, clientID string, spaceID string) string {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal == nil {
+		return ""
+	}
+	return fmt.Sprint(principal)
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second for each
+// key, in bursts of up to burst. It panics if rate is not positive or burst
+// is less than one, as no request would ever be allowed.
+func NewRateLimiter(rate float64, burst int, key RateLimitKey) *RateLimiter {
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		panic(fmt.Sprintf("replicache: invalid rate limit %v", rate))
+	}
+	if burst < 1 {
+		panic(fmt.Sprintf("replicache: invalid rate limit burst %d", burst))
+	}
+	return &RateLimiter{
+		id:      rateLimiterIDs.Add(1),
+		rate:    rate,
+		burst:   float64(burst),
+		key:     key,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// WithRateLimit rejects pushes and pulls over the limit of any of limiters
+// with 429 Too Many Requests and a Retry-After header.
+func WithRateLimit(limiters ...*RateLimiter) Option {
+	return func(o *Options) {
+		o.rateLimiters = append(o.rateLimiters, limiters...)
+	}
+}
+
+// Allow takes a token for key. If none is left it returns false and how
+// long until one is.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ok, wait := l.check(key)
+	if ok {
+		l.buckets[key].tokens--
+	}
+	return ok, wait
+}
+
+// check refills the bucket of key and reports whether it holds a token,
+// without taking it. l.mu must be held.
+func (l *RateLimiter) check(key string) (bool, time.Duration) {
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, at: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.at).Seconds()*l.rate)
+	b.at = now
+	if b.tokens >= 1 {
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep forgets buckets which have refilled, every so often, so idle keys
+// don't accumulate.
+func (l *RateLimiter) sweep(now time.Time) {
+	l.calls++
+	if l.calls%1024 != 0 {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit writes a 429 response if the request is over any limit.
+func (r *Replicache[T]) rateLimit(ctx context.Context, w http.ResponseWriter, op Operation, clientID string, spaceID string) bool {
+	limits := make([]rateLimit, 0, len(r.options.rateLimiters))
+	for _, limiter := range r.options.rateLimiters {
+		key := limiter.key(ctx, op, clientID, spaceID)
+		if key == "" || slices.ContainsFunc(limits, func(l rateLimit) bool { return l.limiter == limiter }) {
+			continue
+		}
+		limits = append(limits, rateLimit{limiter, key})
+	}
+
+	if ok, wait := allowAll(limits); !ok {
+		seconds := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeError(ctx, w, http.StatusTooManyRequests, ErrRateLimited)
+		return false
+	}
+	return true
+}
+
+// rateLimit is the key a request is limited by in one limiter.
+type rateLimit struct {
+	limiter *RateLimiter
+	key     string
+}
+
+// allowAll takes a token from each of limits only if all of them hold one,
+// so a request rejected by one limiter doesn't use up the budget of the
+// others. Otherwise it returns how long until all of them do. The limiters
+// are locked together in the order they were created in, so requests to
+// Replicaches which share limiters configured in other orders can't
+// deadlock.
+func allowAll(limits []rateLimit) (bool, time.Duration) {
+	slices.SortFunc(limits, func(a, b rateLimit) int { return cmp.Compare(a.limiter.id, b.limiter.id) })
+	for _, l := range limits {
+		l.limiter.mu.Lock()
+		defer l.limiter.mu.Unlock()
+	}
+
+	var wait time.Duration
+	for _, l := range limits {
+		if ok, w := l.limiter.check(l.key); !ok {
+			wait = max(wait, w)
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, l := range limits {
+		l.limiter.buckets[l.key].tokens--
+	}
+	return true, 0
+}
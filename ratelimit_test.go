@@ -0,0 +1,105 @@
+package replicache_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/airheartdev/replicache"
+)
+
+func (s *SyncSuite) TestRateLimitByClient() {
+	s.setup(replicache.WithRateLimit(replicache.NewRateLimiter(1000, 2, replicache.ByClient)))
+
+	path := replicache.DefaultPullEndpoint + "/space-1"
+	s.pull(path, 0)
+	s.pull(path, 0)
+
+	w := s.post(path, replicache.PullRequest{ClientID: "client-1"})
+	s.Equal(http.StatusTooManyRequests, w.Code)
+	s.Equal("1", w.Header().Get("Retry-After"))
+
+	// Other clients have their own bucket.
+	w = s.post(path, replicache.PullRequest{ClientID: "client-2"})
+	s.Equal(http.StatusOK, w.Code)
+
+	time.Sleep(5 * time.Millisecond)
+	s.pull(path, 0)
+}
+
+func (s *SyncSuite) TestRateLimitBySpace() {
+	s.setup(replicache.WithRateLimit(replicache.NewRateLimiter(0.1, 1, replicache.BySpace)))
+
+	s.push("space-1")
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{ClientID: "client-2"})
+	s.Equal(http.StatusTooManyRequests, w.Code)
+	s.Equal("10", w.Header().Get("Retry-After"))
+
+	s.push("space-2")
+}
+
+func (s *SyncSuite) TestRateLimiterAllow() {
+	limiter := replicache.NewRateLimiter(1, 1, replicache.ByPrincipal)
+
+	ok, _ := limiter.Allow("alice")
+	s.True(ok)
+	ok, wait := limiter.Allow("alice")
+	s.False(ok)
+	s.InDelta(time.Second, wait, float64(10*time.Millisecond))
+
+	ok, _ = limiter.Allow("bob")
+	s.True(ok)
+}
+
+func (s *SyncSuite) TestRateLimitRejectedTakesNoTokens() {
+	s.setup(replicache.WithRateLimit(
+		replicache.NewRateLimiter(0.001, 1, replicache.ByClient),
+		replicache.NewRateLimiter(0.001, 1, replicache.BySpace),
+	))
+
+	s.push("space-1")
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{ClientID: "client-2"})
+	s.Equal(http.StatusTooManyRequests, w.Code)
+
+	// The rejected push left client-2 its token.
+	w = s.post(replicache.DefaultPushEndpoint+"/space-2", replicache.PushRequest{ClientID: "client-2"})
+	s.Equal(http.StatusOK, w.Code, w.Body.String())
+}
+
+func (s *SyncSuite) TestNewRateLimiterValidates() {
+	s.Panics(func() { replicache.NewRateLimiter(0, 1, replicache.ByClient) })
+	s.Panics(func() { replicache.NewRateLimiter(-1, 1, replicache.ByClient) })
+	s.Panics(func() { replicache.NewRateLimiter(1, 0, replicache.ByClient) })
+}
+
+func (s *SyncSuite) TestRateLimitSharedLimiters() {
+	byClient := replicache.NewRateLimiter(1e9, 1e6, replicache.ByClient)
+	bySpace := replicache.NewRateLimiter(1e9, 1e6, replicache.BySpace)
+	pull := func(ctx context.Context, pr *replicache.PullRequest, spaceID string) (replicache.PullResponse[string], error) {
+		return replicache.PullResponse[string]{}, nil
+	}
+
+	// Limiters shared in opposite orders must not deadlock.
+	handlers := []http.Handler{
+		replicache.New[string](replicache.WithRateLimit(byClient, bySpace)).HandlePull(pull),
+		replicache.New[string](replicache.WithRateLimit(bySpace, byClient)).HandlePull(pull),
+	}
+
+	var wg sync.WaitGroup
+	for _, handler := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				req := httptest.NewRequest(http.MethodPost, replicache.DefaultPullEndpoint+"?spaceID=space-1", strings.NewReader(`{"clientID":"client-1"}`))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set(replicache.ReplicacheRequestIDHeader, "1")
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		}()
+	}
+	wg.Wait()
+}
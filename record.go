@@ -0,0 +1,179 @@
+package replicache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Exchange is a recorded request and its response.
+type Exchange struct {
+	Time   time.Time   `json:"time"`
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Header http.Header `json:"header"`
+	// Request and Response hold the bodies. A body which is not JSON is
+	// recorded as a JSON string.
+	Request  json.RawMessage `json:"request"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// recordedHeaders are the request headers Record keeps.
+var recordedHeaders = []string{"Content-Type", ReplicacheRequestIDHeader}
+
+// Record returns middleware which writes every POST through it, with its
+// response, to w as a line of JSON. Pushes and pulls are both POSTs, while
+// preflights and poke streams pass through unrecorded. Only the headers in
+// recordedHeaders are recorded, so credentials such as the Authorization
+// header and cookies stay out of the capture.
+func Record(w io.Writer) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				writeError(req.Context(), rw, http.StatusBadRequest, err)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			rec := &recordingWriter{ResponseWriter: rw, status: http.StatusOK}
+			next.ServeHTTP(rec, req)
+
+			header := make(http.Header)
+			for _, name := range recordedHeaders {
+				if v := req.Header.Values(name); len(v) > 0 {
+					header[http.CanonicalHeaderKey(name)] = append([]string(nil), v...)
+				}
+			}
+
+			line, err := json.Marshal(Exchange{
+				Time:     time.Now(),
+				Method:   req.Method,
+				Path:     req.URL.RequestURI(),
+				Header:   header,
+				Request:  rawJSON(body),
+				Status:   rec.status,
+				Response: rawJSON(bytes.TrimSpace(rec.body.Bytes())),
+			})
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			w.Write(append(line, '\n'))
+		})
+	}
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func rawJSON(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	if json.Valid(b) {
+		return b
+	}
+	s, _ := json.Marshal(string(b))
+	return s
+}
+
+// ReplayDiff is an exchange whose replayed response differs from the
+// recorded one.
+type ReplayDiff struct {
+	// Line is the line of the exchange in the capture, from 1.
+	Line     int
+	Exchange Exchange
+	Status   int
+	Response json.RawMessage
+}
+
+func (d ReplayDiff) String() string {
+	return fmt.Sprintf("line %d: %s %s\n- %d %s\n+ %d %s",
+		d.Line, d.Exchange.Method, d.Exchange.Path,
+		d.Exchange.Status, d.Exchange.Response,
+		d.Status, d.Response)
+}
+
+// Replay sends the exchanges recorded by Record in capture to handler in
+// order, and returns those whose status or response body differs from the
+// recording. Replaying a capture against a fresh store reproduces the
+// pull responses seen by clients, so a diff points at the push which
+// diverged.
+func Replay(handler http.Handler, capture io.Reader) ([]ReplayDiff, error) {
+	diffs := make([]ReplayDiff, 0)
+
+	scanner := bufio.NewScanner(capture)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		ex := Exchange{}
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return diffs, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		req := httptest.NewRequest(ex.Method, ex.Path, bytes.NewReader(ex.Request))
+		for k, v := range ex.Header {
+			req.Header[k] = v
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		got := rawJSON(bytes.TrimSpace(w.Body.Bytes()))
+		if w.Code != ex.Status || !jsonEqual(ex.Response, got) {
+			diffs = append(diffs, ReplayDiff{
+				Line:     line,
+				Exchange: ex,
+				Status:   w.Code,
+				Response: got,
+			})
+		}
+	}
+
+	return diffs, scanner.Err()
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
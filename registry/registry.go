@@ -0,0 +1,243 @@
+// Package registry lets a single Replicache hold values of different Go types,
+// chosen by key prefix.
+//
+// A Registry maps key prefixes such as "todo/" and "list/" to Go types and
+// their codecs. Replicache, its stores and transactions are instantiated with
+// Value, and each registered Type reads and writes its own values:
+//
+//	reg := registry.New()
+//	todos, _ := registry.Register[Todo](reg, "todo/", replicache.JSONCodec[Todo]{})
+//	rep := replicache.New[registry.Value]()
+//	...
+//	todo, err := todos.Get(tx, "todo/1")
+//
+// Pull patches encode each Value as JSON using its own type.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/airheartdev/replicache"
+)
+
+var (
+	ErrPrefixExists       = errors.New("prefix already registered")
+	ErrUnregisteredPrefix = errors.New("no type registered for key")
+	ErrWrongType          = errors.New("key belongs to another type")
+)
+
+type (
+	Registry struct {
+		mu sync.RWMutex
+		// kinds is ordered by descending prefix length, so the first match
+		// is the longest.
+		kinds []*kind
+	}
+
+	// Type reads and writes values of type V under its prefix.
+	Type[V any] struct {
+		reg  *Registry
+		kind *kind
+	}
+
+	// Value holds a value of any registered type.
+	Value struct {
+		kind *kind
+		v    any
+	}
+
+	kind struct {
+		prefix    string
+		marshal   func(v any) ([]byte, error)
+		unmarshal func(data []byte) (any, error)
+		clone     func(v any) any
+	}
+
+	// envelope holds the encoded value inline when the codec produces JSON,
+	// and as bytes otherwise.
+	envelope struct {
+		Prefix string          `json:"prefix"`
+		Data   json.RawMessage `json:"data,omitempty"`
+		Bytes  []byte          `json:"bytes,omitempty"`
+	}
+)
+
+func New() *Registry {
+	return &Registry{}
+}
+
+// Register maps keys starting with prefix to V. When prefixes overlap, the
+// longest matching prefix wins.
+func Register[V any](reg *Registry, prefix string, codec replicache.Codec[V]) (Type[V], error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, k := range reg.kinds {
+		if k.prefix == prefix {
+			return Type[V]{}, fmt.Errorf("%w: %q", ErrPrefixExists, prefix)
+		}
+	}
+
+	k := &kind{
+		prefix:  prefix,
+		marshal: func(v any) ([]byte, error) { return codec.Marshal(v.(V)) },
+		unmarshal: func(data []byte) (any, error) {
+			return codec.Unmarshal(data)
+		},
+		clone: func(v any) any { return replicache.Clone(v.(V)) },
+	}
+
+	reg.kinds = append(reg.kinds, k)
+	sort.SliceStable(reg.kinds, func(i, j int) bool {
+		return len(reg.kinds[i].prefix) > len(reg.kinds[j].prefix)
+	})
+
+	return Type[V]{reg: reg, kind: k}, nil
+}
+
+// Codec encodes Values along with their prefix, so they can be decoded into
+// the right type. Persistent stores holding Values must use it.
+func (r *Registry) Codec() replicache.Codec[Value] {
+	return registryCodec{reg: r}
+}
+
+func (r *Registry) lookup(key string) (*kind, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.kinds {
+		if strings.HasPrefix(key, k.prefix) {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnregisteredPrefix, key)
+}
+
+func (r *Registry) kindByPrefix(prefix string) (*kind, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.kinds {
+		if k.prefix == prefix {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: prefix %q", ErrUnregisteredPrefix, prefix)
+}
+
+func (t Type[V]) Prefix() string {
+	return t.kind.prefix
+}
+
+// Value wraps v for storage.
+func (t Type[V]) Value(v V) Value {
+	return Value{kind: t.kind, v: v}
+}
+
+// Get reads the value at key, which must belong to this type.
+func (t Type[V]) Get(tx replicache.ReadTransaction[Value], key string) (*V, error) {
+	if err := t.check(key); err != nil {
+		return nil, err
+	}
+
+	val, err := tx.Get(key)
+	if err != nil || val == nil {
+		return nil, err
+	}
+
+	v, ok := val.v.(V)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q holds %T", ErrWrongType, key, val.v)
+	}
+	return &v, nil
+}
+
+// Put writes v at key, which must belong to this type.
+func (t Type[V]) Put(tx replicache.WriteTransaction[Value], key string, v *V) error {
+	if err := t.check(key); err != nil {
+		return err
+	}
+
+	val := t.Value(*v)
+	return tx.Put(key, &val)
+}
+
+func (t Type[V]) check(key string) error {
+	k, err := t.reg.lookup(key)
+	if err != nil {
+		return err
+	}
+	if k != t.kind {
+		return fmt.Errorf("%w: %q is registered to %q", ErrWrongType, key, k.prefix)
+	}
+	return nil
+}
+
+// Interface returns the wrapped value.
+func (v Value) Interface() any {
+	return v.v
+}
+
+// MarshalJSON encodes the wrapped value, so pull patches carry each value in
+// the JSON form of its own type.
+func (v Value) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.v)
+}
+
+func (v Value) Clone() Value {
+	if v.kind == nil {
+		return v
+	}
+	return Value{kind: v.kind, v: v.kind.clone(v.v)}
+}
+
+type registryCodec struct {
+	reg *Registry
+}
+
+func (c registryCodec) Marshal(value Value) ([]byte, error) {
+	if value.kind == nil {
+		return nil, ErrUnregisteredPrefix
+	}
+
+	data, err := value.kind.marshal(value.v)
+	if err != nil {
+		return nil, err
+	}
+
+	env := envelope{Prefix: value.kind.prefix}
+	if json.Valid(data) {
+		env.Data = data
+	} else {
+		env.Bytes = data
+	}
+	return json.Marshal(env)
+}
+
+func (c registryCodec) Unmarshal(data []byte) (Value, error) {
+	env := envelope{}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Value{}, err
+	}
+
+	k, err := c.reg.kindByPrefix(env.Prefix)
+	if err != nil {
+		return Value{}, err
+	}
+
+	raw := []byte(env.Data)
+	if env.Bytes != nil {
+		raw = env.Bytes
+	}
+
+	v, err := k.unmarshal(raw)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{kind: k, v: v}, nil
+}
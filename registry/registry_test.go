@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Todo struct {
+	Text  string   `json:"text"`
+	Lists []string `json:"lists"`
+}
+
+type List struct {
+	Name string `json:"name"`
+}
+
+func (t Todo) Clone() Todo {
+	t.Lists = append([]string(nil), t.Lists...)
+	return t
+}
+
+func setup(t *testing.T) (*Registry, Type[Todo], Type[List]) {
+	reg := New()
+	todos, err := Register[Todo](reg, "todo/", replicache.JSONCodec[Todo]{})
+	require.NoError(t, err)
+	lists, err := Register[List](reg, "list/", replicache.JSONCodec[List]{})
+	require.NoError(t, err)
+
+	_, err = Register[List](reg, "list/", replicache.JSONCodec[List]{})
+	require.ErrorIs(t, err, ErrPrefixExists)
+
+	return reg, todos, lists
+}
+
+func TestTypedTransaction(t *testing.T) {
+	a := assert.New(t)
+	_, todos, lists := setup(t)
+
+	backend := memory.New[Value]()
+	tx := backend.Transaction("Space1", "client-1", 1)
+
+	a.NoError(todos.Put(tx, "todo/1", &Todo{Text: "write tests", Lists: []string{"list/1"}}))
+	a.NoError(lists.Put(tx, "list/1", &List{Name: "work"}))
+
+	a.ErrorIs(lists.Put(tx, "todo/2", &List{}), ErrWrongType)
+	a.ErrorIs(todos.Put(tx, "user/1", &Todo{}), ErrUnregisteredPrefix)
+	_, err := lists.Get(tx, "todo/1")
+	a.ErrorIs(err, ErrWrongType)
+
+	todo, err := todos.Get(tx, "todo/1")
+	a.NoError(err)
+	a.Equal("write tests", todo.Text)
+
+	// Values are copied using the Cloner of their type.
+	todo.Lists[0] = "changed"
+	todo, _ = todos.Get(tx, "todo/1")
+	a.Equal("list/1", todo.Lists[0])
+
+	a.NoError(tx.Flush())
+
+	list, err := backend.GetEntry("Space1", "list/1")
+	a.NoError(err)
+	a.Equal(List{Name: "work"}, list.Interface())
+}
+
+func TestPullEncodesEachType(t *testing.T) {
+	a := assert.New(t)
+	_, todos, lists := setup(t)
+
+	rep := replicache.New[Value]()
+	rep.SetStore(memory.New[Value]())
+	rep.Register("seed", func(ctx context.Context, tx replicache.ReadWriteTransaction[Value], m replicache.Mutation) error {
+		if err := todos.Put(tx, "todo/1", &Todo{Text: "write tests", Lists: []string{"list/1"}}); err != nil {
+			return err
+		}
+		return lists.Put(tx, "list/1", &List{Name: "work"})
+	})
+
+	ctx := context.Background()
+	require.NoError(t, rep.Push(ctx, &replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: []replicache.Mutation{{ID: 1, Name: "seed"}},
+	}, "Space1"))
+
+	resp, err := rep.Pull(ctx, &replicache.PullRequest{ClientID: "client-1"}, "Space1")
+	require.NoError(t, err)
+
+	data, err := json.Marshal(resp.Patch)
+	require.NoError(t, err)
+	a.JSONEq(`[
+		{"op":"clear"},
+		{"op":"put","key":"list/1","value":{"name":"work"}},
+		{"op":"put","key":"todo/1","value":{"text":"write tests","lists":["list/1"]}}
+	]`, string(data))
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	a := assert.New(t)
+	reg, todos, lists := setup(t)
+	dir := t.TempDir()
+
+	backend, err := memory.Open[Value](dir, memory.WithCodec(reg.Codec()))
+	require.NoError(t, err)
+
+	tx := backend.Transaction("Space1", "client-1", 1)
+	a.NoError(todos.Put(tx, "todo/1", &Todo{Text: "persist"}))
+	a.NoError(lists.Put(tx, "list/1", &List{Name: "work"}))
+	a.NoError(tx.Flush())
+	a.NoError(backend.Close())
+
+	backend, err = memory.Open[Value](dir, memory.WithCodec(reg.Codec()))
+	require.NoError(t, err)
+	defer backend.Close()
+
+	tx = backend.Transaction("Space1", "client-1", 2)
+	todo, err := todos.Get(tx, "todo/1")
+	a.NoError(err)
+	a.Equal("persist", todo.Text)
+
+	list, err := lists.Get(tx, "list/1")
+	a.NoError(err)
+	a.Equal("work", list.Name)
+}
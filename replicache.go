@@ -1,46 +1,135 @@
 package replicache
 
-import "context"
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
 
 type (
 	Replicache[T any] struct {
 		options  *Options
-		mutators map[string]Mutator
+		mutators map[string]Mutator[T]
+		store    Store[T]
+		mu       sync.Mutex
+		requests *requestCache
+		hooks    hooks[T]
+		usage    map[string]*spaceUsage
+		strategy SyncStrategy[T]
+		revoked  revokedClients
 	}
 
 	Options struct {
-		authFn AuthFn
+		authFn          AuthFn
+		adminAuthFn     AuthFn
+		spaceAuthorizer SpaceAuthorizer
+		spaceResolver   SpaceResolver
+		requireSpace    bool
+		poker           Poker
+		pokeQueryToken  bool
+		allowOrigin     func(origin string) bool
+		dedupeTTL       time.Duration
+		mutationLog     MutationLog
+		metrics         Metrics
+		tracer          Tracer
+		logger          *slog.Logger
+		failurePolicy   FailurePolicy
+		mutatorTimeout  time.Duration
+		pushTimeout     time.Duration
+		rateLimiters    []*RateLimiter
+		quota           func(spaceID string) Quota
 	}
 
-	AuthFn func(ctx context.Context, token string) bool
+	// AuthFn authenticates the raw Authorization header of a request. It
+	// returns the principal making the request, which is stored in the request
+	// context, and false if the request should be rejected.
+	AuthFn func(ctx context.Context, token string) (principal any, ok bool)
+
+	// SpaceAuthorizer decides whether principal may perform op on spaceID. A
+	// non-nil error rejects the request with 403 Forbidden. The error is
+	// logged rather than sent to the client.
+	SpaceAuthorizer func(ctx context.Context, principal any, spaceID string, op Operation) error
+
+	// Operation identifies the kind of request being authorized.
+	Operation string
+)
+
+const (
+	OperationPush Operation = "push"
+	OperationPull Operation = "pull"
 )
 
 func New[T any](options ...Option) *Replicache[T] {
 	r := new(Replicache[T])
 
 	opts := &Options{
-		authFn: func(ctx context.Context, token string) bool { return true },
+		authFn: func(ctx context.Context, token string) (any, bool) { return nil, true },
+		spaceResolver: SpaceFromFirst(
+			SpaceFromPathValue(SpaceIDParam),
+			SpaceFromURLParam(SpaceIDParam),
+			SpaceFromQuery(SpaceIDParam),
+		),
+		poker:   NewPokeBroker(),
+		metrics: nopMetrics{},
+		tracer:  nopTracer{},
 	}
 	for _, option := range options {
 		option(opts)
 	}
 	r.options = opts
+	r.usage = make(map[string]*spaceUsage)
+	r.revoked.ids = make(map[string]bool)
+	r.revoked.watchers = make(map[string]map[chan struct{}]struct{})
+	r.strategy = PerSpaceVersion[T]()
+
+	if opts.dedupeTTL > 0 {
+		r.requests = newRequestCache(opts.dedupeTTL)
+	}
 
 	return r
 }
 
 type Option func(o *Options)
-type Mutator func(Mutation)
 
-func WithAuth(fn func(ctx context.Context, token string) bool) Option {
+// Mutator applies a single mutation to tx. The principal returned by the
+// AuthFn is available from ctx with PrincipalFromContext.
+type Mutator[T any] func(ctx context.Context, tx ReadWriteTransaction[T], m Mutation) error
+
+// WithLogger sets the logger for push and pull handling. Records carry the
+// request ID. Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		o.logger = logger
+	}
+}
+
+// logger returns the logger for the request in ctx.
+func (r *Replicache[T]) logger(ctx context.Context) *slog.Logger {
+	logger := r.options.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("requestID", RequestIDFromContext(ctx))
+}
+
+func WithAuth(fn AuthFn) Option {
 	return func(o *Options) {
 		o.authFn = fn
 	}
 }
 
-func (r *Replicache[T]) Register(name string, mutator Mutator) error {
+// WithSpaceAuthorizer sets a hook which is invoked for every push and pull
+// once the request has been authenticated.
+func WithSpaceAuthorizer(fn SpaceAuthorizer) Option {
+	return func(o *Options) {
+		o.spaceAuthorizer = fn
+	}
+}
+
+func (r *Replicache[T]) Register(name string, mutator Mutator[T]) error {
 	if r.mutators == nil {
-		r.mutators = make(map[string]Mutator)
+		r.mutators = make(map[string]Mutator[T])
 	}
 
 	if r.mutators[name] != nil {
@@ -51,6 +140,12 @@ func (r *Replicache[T]) Register(name string, mutator Mutator) error {
 	return nil
 }
 
-func (r *Replicache[T]) Transact() {
-
+// Mutate invokes the mutator registered for m.Name through the middleware
+// added with Use.
+func (r *Replicache[T]) Mutate(ctx context.Context, tx ReadWriteTransaction[T], m Mutation) error {
+	mutator := Mutator[T](r.dispatch)
+	for i := len(r.hooks.middleware) - 1; i >= 0; i-- {
+		mutator = r.hooks.middleware[i](mutator)
+	}
+	return mutator(ctx, tx, m)
 }
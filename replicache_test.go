@@ -3,9 +3,11 @@ package replicache
 import (
 	"bytes"
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -18,7 +20,7 @@ func TestMainSuite(t *testing.T) {
 	suite.Run(t, new(MainSuite))
 }
 
-type MockAuth func(ctx context.Context, token string) bool
+type MockAuth func(ctx context.Context, token string) (any, bool)
 
 type Todo struct {
 	ID   int
@@ -27,27 +29,31 @@ type Todo struct {
 
 func (suite *MainSuite) TestNewReplicacheInstance() {
 	authCalled := 0
-	authFn := func(ctx context.Context, token string) bool {
+	authFn := func(ctx context.Context, token string) (any, bool) {
 		authCalled++
-		return true
+		return nil, true
 	}
 
 	r := New[Todo](WithAuth(authFn))
-	r.Register("todo", func(m Mutation) {
+	r.Register("todo", func(ctx context.Context, tx ReadWriteTransaction[Todo], m Mutation) error {
 		// m.Name
+		return nil
 	})
 }
 
 func (s *MainSuite) TestRequestWithAuth() {
 	ctx := context.TODO()
 	authCalled := 0
-	authFn := func(ctx context.Context, token string) bool {
+	authFn := func(ctx context.Context, token string) (any, bool) {
 		authCalled++
-		return token == "TOKEN"
+		return "user-1", token == "TOKEN"
 	}
 
 	r := New[Todo](WithAuth(authFn))
-	handler := r.HandlePull(func(pr *PullRequest, spaceID string) (PullResponse[Todo], error) {
+	handler := r.HandlePull(func(ctx context.Context, pr *PullRequest, spaceID string) (PullResponse[Todo], error) {
+		principal, ok := PrincipalFromContext(ctx)
+		s.True(ok)
+		s.Equal("user-1", principal)
 		return PullResponse[Todo]{}, nil
 	})
 
@@ -66,3 +72,318 @@ func (s *MainSuite) TestRequestWithAuth() {
 	s.Equal(1, authCalled)
 	s.Equal(200, buf.Result().StatusCode)
 }
+
+func (s *MainSuite) TestSpaceAuthorizer() {
+	authFn := func(ctx context.Context, token string) (any, bool) {
+		return token, true
+	}
+
+	var ops []Operation
+	authorizer := func(ctx context.Context, principal any, spaceID string, op Operation) error {
+		ops = append(ops, op)
+		if principal != "alice" || spaceID != "alice-space" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}
+
+	r := New[Todo](WithAuth(authFn), WithSpaceAuthorizer(authorizer))
+	handler := r.HandlePush(func(ctx context.Context, pr *PushRequest, spaceID string) error {
+		return nil
+	})
+
+	for _, tc := range []struct {
+		token   string
+		spaceID string
+		status  int
+	}{
+		{"alice", "alice-space", http.StatusOK},
+		{"bob", "alice-space", http.StatusForbidden},
+	} {
+		buf := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", DefaultPushEndpoint+"?spaceID="+tc.spaceID, bytes.NewBufferString(`{}`))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add(ReplicacheRequestIDHeader, "1")
+		req.Header.Add(authorizationHeader, tc.token)
+
+		handler(buf, req)
+		s.Equal(tc.status, buf.Result().StatusCode)
+	}
+
+	s.Equal([]Operation{OperationPush, OperationPush}, ops)
+}
+
+func (s *MainSuite) TestMutateWithPrincipal() {
+	r := New[Todo]()
+	s.NoError(r.Register("whoami", func(ctx context.Context, tx ReadWriteTransaction[Todo], m Mutation) error {
+		principal, _ := PrincipalFromContext(ctx)
+		if principal != "alice" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}))
+
+	ctx := ContextWithPrincipal(context.Background(), "alice")
+	s.NoError(r.Mutate(ctx, nil, Mutation{Name: "whoami"}))
+	s.Error(r.Mutate(context.Background(), nil, Mutation{Name: "whoami"}))
+	s.ErrorIs(r.Mutate(ctx, nil, Mutation{Name: "missing"}), ErrMutatorNotFound)
+}
+
+func (s *MainSuite) TestSpaceResolver() {
+	var resolved []string
+	pull := func(ctx context.Context, pr *PullRequest, spaceID string) (PullResponse[Todo], error) {
+		resolved = append(resolved, spaceID)
+		return PullResponse[Todo]{}, nil
+	}
+
+	newRequest := func(target string) *http.Request {
+		req := httptest.NewRequest("POST", target, bytes.NewBufferString(`{}`))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add(ReplicacheRequestIDHeader, "1")
+		req.Header.Add("X-Space", "header-space")
+		return req
+	}
+
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		target  string
+		status  int
+		spaceID string
+	}{
+		{"default query", nil, "/pull?spaceID=query-space", http.StatusOK, "query-space"},
+		{"empty allowed", nil, "/pull", http.StatusOK, ""},
+		{"empty rejected", []Option{WithRequiredSpace()}, "/pull", http.StatusBadRequest, ""},
+		{"header", []Option{WithSpaceResolver(SpaceFromHeader("X-Space"))}, "/pull?spaceID=query-space", http.StatusOK, "header-space"},
+		{"principal", []Option{
+			WithAuth(func(ctx context.Context, token string) (any, bool) { return "alice", true }),
+			WithSpaceResolver(SpaceFromPrincipal(func(principal any) (string, error) {
+				return "user/" + principal.(string), nil
+			})),
+		}, "/pull", http.StatusOK, "user/alice"},
+		{"resolver error", []Option{WithSpaceResolver(func(req *http.Request) (string, error) {
+			return "", errors.New("bad space")
+		})}, "/pull", http.StatusBadRequest, ""},
+	} {
+		resolved = nil
+		r := New[Todo](tc.options...)
+
+		buf := httptest.NewRecorder()
+		r.HandlePull(pull)(buf, newRequest(tc.target))
+
+		s.Equal(tc.status, buf.Result().StatusCode, tc.name)
+		if tc.status == http.StatusOK {
+			s.Equal([]string{tc.spaceID}, resolved, tc.name)
+		} else {
+			s.Empty(resolved, tc.name)
+		}
+	}
+}
+
+func (s *MainSuite) TestErrorBodyCarriesRequestID() {
+	r := New[Todo]()
+	handler := r.HandlePush(func(ctx context.Context, pr *PushRequest, spaceID string) error {
+		s.Equal("req-1", RequestIDFromContext(ctx))
+		return errors.New("boom")
+	})
+
+	req := httptest.NewRequest("POST", DefaultPushEndpoint, bytes.NewBufferString(`{}`))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(ReplicacheRequestIDHeader, "req-1")
+
+	buf := httptest.NewRecorder()
+	handler(buf, req)
+
+	s.Equal(http.StatusInternalServerError, buf.Code)
+	// The cause of a server failure is logged rather than sent.
+	s.JSONEq(`{"error":"Internal Server Error","requestID":"req-1"}`, buf.Body.String())
+}
+
+func (s *MainSuite) TestRequestDedupe() {
+	calls := 0
+	fail := true
+	r := New[Todo](WithRequestDedupe(time.Minute))
+	handler := r.HandlePush(func(ctx context.Context, pr *PushRequest, spaceID string) error {
+		calls++
+		if fail {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	push := func(clientID, requestID string) int {
+		req := httptest.NewRequest("POST", DefaultPushEndpoint, bytes.NewBufferString(`{"clientID":"`+clientID+`"}`))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add(ReplicacheRequestIDHeader, requestID)
+
+		buf := httptest.NewRecorder()
+		handler(buf, req)
+		return buf.Code
+	}
+
+	s.Equal(http.StatusInternalServerError, push("c1", "req-1"))
+	fail = false
+	s.Equal(http.StatusOK, push("c1", "req-1"))
+	s.Equal(http.StatusOK, push("c1", "req-1"))
+	s.Equal(2, calls)
+
+	s.Equal(http.StatusOK, push("c1", "req-2"))
+	s.Equal(3, calls)
+
+	// Request IDs are only unique per client.
+	s.Equal(http.StatusOK, push("c2", "req-2"))
+	s.Equal(4, calls)
+}
+
+func (s *MainSuite) TestRequestDedupeInFlight() {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	r := New[Todo](WithRequestDedupe(time.Minute))
+	handler := r.HandlePush(func(ctx context.Context, pr *PushRequest, spaceID string) error {
+		close(started)
+		<-finish
+		return nil
+	})
+
+	push := func() int {
+		req := httptest.NewRequest("POST", DefaultPushEndpoint, bytes.NewBufferString(`{"clientID":"c1"}`))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add(ReplicacheRequestIDHeader, "req-1")
+
+		buf := httptest.NewRecorder()
+		handler(buf, req)
+		return buf.Code
+	}
+
+	first := make(chan int)
+	go func() { first <- push() }()
+	<-started
+
+	// A retry while the push is processed must not run it again.
+	s.Equal(http.StatusConflict, push())
+	close(finish)
+	s.Equal(http.StatusOK, <-first)
+	s.Equal(http.StatusOK, push())
+}
+
+func (s *MainSuite) TestRequestCacheExpiry() {
+	c := newRequestCache(time.Millisecond)
+	one := requestKey{"c1", "", "req-1"}
+	two := requestKey{"c1", "", "req-2"}
+
+	s.Equal(requestNew, c.reserve(one))
+	c.release(one, true)
+	s.Equal(requestDone, c.reserve(one))
+
+	// Failed requests are forgotten.
+	s.Equal(requestNew, c.reserve(two))
+	c.release(two, false)
+	s.Equal(requestNew, c.reserve(two))
+
+	time.Sleep(2 * time.Millisecond)
+	s.Equal(requestNew, c.reserve(one))
+	s.Empty(c.expiries)
+	s.Len(c.entries, 2)
+}
+
+type tags struct {
+	names []string
+}
+
+func (t *tags) Clone() tags {
+	return tags{names: append([]string(nil), t.names...)}
+}
+
+func (s *MainSuite) TestClonePointerReceiver() {
+	v := tags{names: []string{"a"}}
+	c := Clone(v)
+	c.names[0] = "b"
+	s.Equal("a", v.names[0])
+}
+
+func (s *MainSuite) TestUsageChange() {
+	usage := &spaceUsage{sizes: map[string]int64{"a": 3, "b": 5}, bytes: 8}
+	change := &usageChange{usage: usage, sizes: map[string]int64{"a": -1, "b": 2, "c": 4}}
+	change.apply()
+	s.Equal(map[string]int64{"b": 2, "c": 4}, usage.sizes)
+	s.Equal(int64(6), usage.bytes)
+
+	_, err := sizeOf(make(chan int))
+	s.Error(err)
+}
+
+func (s *MainSuite) TestStringKey() {
+	todos := StringKey("todo/")
+
+	key, err := todos.Encode("abc")
+	s.NoError(err)
+	s.Equal("todo/abc", key)
+
+	key, err = todos.Encode("a%20b")
+	s.NoError(err)
+	s.Equal("todo/a%20b", key)
+
+	id, err := todos.Decode(key)
+	s.NoError(err)
+	s.Equal("a%20b", id)
+
+	_, err = todos.Encode("")
+	s.ErrorIs(err, ErrInvalidKey)
+	_, err = todos.Encode("a/b")
+	s.ErrorIs(err, ErrInvalidKey)
+
+	_, err = todos.Decode("list/abc")
+	s.ErrorIs(err, ErrInvalidKey)
+	_, err = todos.Decode("todo/")
+	s.ErrorIs(err, ErrInvalidKey)
+	_, err = todos.Decode("todo/a/b")
+	s.ErrorIs(err, ErrInvalidKey)
+
+	s.True(todos.Match("todo/abc"))
+	s.False(todos.Match("todo/abc/comments/1"))
+	s.False(todos.Match("todos/abc"))
+
+	start, end := todos.Range()
+	s.Equal("todo/", start)
+	s.Equal("todo0", end)
+}
+
+func (s *MainSuite) TestEscapedStringKey() {
+	pages := EscapedStringKey("page/")
+
+	key, err := pages.Encode("https://example.com/a b")
+	s.NoError(err)
+	s.Equal("page/https:%2F%2Fexample.com%2Fa%20b", key)
+	s.True(pages.Match(key))
+
+	id, err := pages.Decode(key)
+	s.NoError(err)
+	s.Equal("https://example.com/a b", id)
+
+	_, err = pages.Encode("")
+	s.ErrorIs(err, ErrInvalidKey)
+	_, err = pages.Decode("page/%zz")
+	s.ErrorIs(err, ErrInvalidKey)
+	s.False(pages.Match("page/a/comments/1"))
+}
+
+func (s *MainSuite) TestClientViewsExpire() {
+	now := time.Now()
+	views := newClientViews(time.Hour)
+	views.now = func() time.Time { return now }
+
+	s.NoError(views.PutClientView("space-1", "client-1", ClientView{Cookie: 1}))
+	s.NoError(views.PutClientView("space-1", "client-2", ClientView{Cookie: 1}))
+
+	// client-1 keeps pulling, while client-2 is gone.
+	now = now.Add(40 * time.Minute)
+	s.NoError(views.PruneClientViews("space-1", "client-1", 1))
+	now = now.Add(40 * time.Minute)
+	s.NoError(views.PutClientView("space-1", "client-1", ClientView{Cookie: 2}))
+
+	_, ok, _ := views.GetClientView("space-1", "client-1", 2)
+	s.True(ok)
+	_, ok, _ = views.GetClientView("space-1", "client-2", 1)
+	s.False(ok)
+	s.Len(views.used, 1)
+}
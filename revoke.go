@@ -0,0 +1,73 @@
+package replicache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClientRevoked is returned to a client revoked with RevokeClient.
+var ErrClientRevoked = errors.New("client revoked")
+
+type revokedClients struct {
+	mu  sync.RWMutex
+	ids map[string]bool
+	// watchers holds a channel for each open poke stream of a client, which
+	// is closed when the client is revoked.
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+// RevokeClient rejects every later push and pull from clientID with 403
+// Forbidden, until RestoreClient is called, and closes its open poke
+// streams. Revocations are held in memory, so an application which keeps
+// them must revoke its clients again after a restart.
+func (r *Replicache[T]) RevokeClient(clientID string) {
+	r.revoked.mu.Lock()
+	defer r.revoked.mu.Unlock()
+
+	r.revoked.ids[clientID] = true
+	for ch := range r.revoked.watchers[clientID] {
+		close(ch)
+	}
+	delete(r.revoked.watchers, clientID)
+}
+
+// RestoreClient lifts the revocation of clientID.
+func (r *Replicache[T]) RestoreClient(clientID string) {
+	r.revoked.mu.Lock()
+	defer r.revoked.mu.Unlock()
+
+	delete(r.revoked.ids, clientID)
+}
+
+func (r *Replicache[T]) isRevoked(clientID string) bool {
+	r.revoked.mu.RLock()
+	defer r.revoked.mu.RUnlock()
+
+	return r.revoked.ids[clientID]
+}
+
+// watchRevocation returns a channel which is closed when clientID is
+// revoked, and a function which stops watching it. It returns false if
+// clientID is already revoked.
+func (r *Replicache[T]) watchRevocation(clientID string) (<-chan struct{}, func(), bool) {
+	r.revoked.mu.Lock()
+	defer r.revoked.mu.Unlock()
+
+	if r.revoked.ids[clientID] {
+		return nil, nil, false
+	}
+	ch := make(chan struct{})
+	if r.revoked.watchers[clientID] == nil {
+		r.revoked.watchers[clientID] = make(map[chan struct{}]struct{})
+	}
+	r.revoked.watchers[clientID][ch] = struct{}{}
+
+	return ch, func() {
+		r.revoked.mu.Lock()
+		defer r.revoked.mu.Unlock()
+		delete(r.revoked.watchers[clientID], ch)
+		if len(r.revoked.watchers[clientID]) == 0 {
+			delete(r.revoked.watchers, clientID)
+		}
+	}, true
+}
@@ -0,0 +1,277 @@
+package replicache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// ClientView is a client view record: the row version of every entry
+	// sent to a client group, as of a cookie.
+	ClientView struct {
+		Cookie uint64            `json:"cookie"`
+		Rows   map[string]uint64 `json:"rows"`
+	}
+
+	// ClientViewStore keeps the client view records of RowVersioning. The
+	// protocol has no client groups, so each client is its own group.
+	ClientViewStore interface {
+		// GetClientView returns the view of clientGroupID in spaceID at
+		// cookie, and false if it is not kept.
+		GetClientView(spaceID string, clientGroupID string, cookie uint64) (ClientView, bool, error)
+		// GetLatestCookie returns the newest cookie of any view kept for
+		// clientGroupID in spaceID, or 0.
+		GetLatestCookie(spaceID string, clientGroupID string) (uint64, error)
+		PutClientView(spaceID string, clientGroupID string, view ClientView) error
+		// PruneClientViews drops the views of clientGroupID in spaceID
+		// older than cookie.
+		PruneClientViews(spaceID string, clientGroupID string, cookie uint64) error
+	}
+
+	// VisibilityFunc reports whether entry may be sent to clientID. The
+	// principal of the request is available from ctx with
+	// PrincipalFromContext.
+	VisibilityFunc[T any] func(ctx context.Context, clientID string, entry *Entry[T]) bool
+
+	RowVersioningOption[T any] func(rv *rowVersioning[T])
+
+	rowVersioning[T any] struct {
+		perSpaceVersion[T]
+		views    ClientViewStore
+		fallback *clientViews
+		visible  VisibilityFunc[T]
+	}
+
+	// clientViews keeps client view records in memory, for stores which
+	// aren't a ClientViewStore. The views of a client group are used by
+	// each of its pulls, and expire once unused for ttl.
+	clientViews struct {
+		mu    sync.Mutex
+		ttl   time.Duration
+		now   func() time.Time
+		views map[viewKey]map[uint64]ClientView
+		used  map[viewKey]time.Time
+		swept time.Time
+	}
+
+	viewKey struct {
+		spaceID       string
+		clientGroupID string
+	}
+)
+
+// DefaultClientViewTTL is how long client view records kept in memory by
+// RowVersioning outlive the last pull of their client.
+const DefaultClientViewTTL = 24 * time.Hour
+
+// RowVersioning keeps a client view record of the row version of every
+// entry sent to each client, and answers a pull by diffing the entries of
+// the space it may see against the record for its cookie. Cookies count the
+// records of a client rather than versions of the space, and tombstones are
+// never needed.
+//
+// Records are kept in the store when it is a ClientViewStore, as the memory
+// and bolt backends are, so they're dropped along with pruned clients and
+// reset spaces, and once unused for a while by the store's own expiry, the
+// memory backend's PruneClients or the bolt backend's ExpireClientViews.
+// Otherwise they're kept in memory until their client hasn't
+// pulled for DefaultClientViewTTL, see WithClientViewTTL, and every client
+// is sent a full snapshot after a restart. Every pull reads the whole space.
+func RowVersioning[T any](options ...RowVersioningOption[T]) SyncStrategy[T] {
+	rv := &rowVersioning[T]{
+		fallback: newClientViews(DefaultClientViewTTL),
+	}
+	for _, option := range options {
+		option(rv)
+	}
+	return rv
+}
+
+// WithVisibility only sends clients the entries visible returns true for.
+// An entry which stops being visible is deleted from the client.
+func WithVisibility[T any](visible VisibilityFunc[T]) RowVersioningOption[T] {
+	return func(rv *rowVersioning[T]) {
+		rv.visible = visible
+	}
+}
+
+// WithClientViewTTL sets how long the client view records kept in memory
+// outlive the last pull of their client, or keeps them forever if ttl is
+// zero. A client which pulls after its records expired is sent a full
+// snapshot. Records kept in a ClientViewStore expire as the store expires
+// them instead.
+func WithClientViewTTL[T any](ttl time.Duration) RowVersioningOption[T] {
+	return func(rv *rowVersioning[T]) {
+		rv.fallback.ttl = ttl
+	}
+}
+
+// WithClientViewStore keeps client view records in views rather than the
+// store, e.g. a bolt backend beside a store which keeps none.
+func WithClientViewStore[T any](views ClientViewStore) RowVersioningOption[T] {
+	return func(rv *rowVersioning[T]) {
+		rv.views = views
+	}
+}
+
+func (rv *rowVersioning[T]) Pull(ctx context.Context, store Store[T], pr *PullRequest, spaceID string) (uint64, []PatchOperation[T], error) {
+	views := rv.viewsOf(store)
+	prev, known, err := views.GetClientView(spaceID, pr.ClientID, pr.Cookie)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	entries, err := store.GetEntries(spaceID, "")
+	if err != nil {
+		return 0, nil, err
+	}
+	rows := make([]*Entry[T], 0)
+	for _, entry := range entries {
+		if rv.visible != nil && !rv.visible(ctx, pr.ClientID, entry) {
+			continue
+		}
+		rows = append(rows, entry)
+	}
+	patch := diffView(prev.Rows, known, rows)
+
+	// The client has the view for its cookie, so older ones are dropped.
+	if err := views.PruneClientViews(spaceID, pr.ClientID, pr.Cookie); err != nil {
+		return 0, nil, err
+	}
+	if known && len(patch) == 0 {
+		return pr.Cookie, patch, nil
+	}
+
+	latest, err := views.GetLatestCookie(spaceID, pr.ClientID)
+	if err != nil {
+		return 0, nil, err
+	}
+	view := ClientView{
+		Cookie: max(latest, pr.Cookie) + 1,
+		Rows:   make(map[string]uint64, len(rows)),
+	}
+	for _, row := range rows {
+		view.Rows[row.Key] = row.Version
+	}
+	if err := views.PutClientView(spaceID, pr.ClientID, view); err != nil {
+		return 0, nil, err
+	}
+	return view.Cookie, patch, nil
+}
+
+// viewsOf returns where the client view records of store are kept.
+func (rv *rowVersioning[T]) viewsOf(store Store[T]) ClientViewStore {
+	if rv.views != nil {
+		return rv.views
+	}
+	if views, ok := store.(ClientViewStore); ok {
+		return views
+	}
+	return rv.fallback
+}
+
+// diffView returns the patch taking a client from prev to rows. Without a
+// previous view it is a clear and every row.
+func diffView[T any](prev map[string]uint64, known bool, rows []*Entry[T]) []PatchOperation[T] {
+	patch := []PatchOperation[T]{}
+	if !known {
+		patch = append(patch, PatchOperation[T]{Op: PatchClear})
+	}
+
+	live := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		live[row.Key] = true
+		if version, sent := prev[row.Key]; !sent || version != row.Version {
+			key, value := row.Key, row.Value
+			patch = append(patch, PatchOperation[T]{Op: PatchPut, Key: &key, Value: &value})
+		}
+	}
+	if !known {
+		return patch
+	}
+	for key := range prev {
+		if !live[key] {
+			patch = append(patch, PatchOperation[T]{Op: PatchDel, Key: &key})
+		}
+	}
+	return patch
+}
+
+func newClientViews(ttl time.Duration) *clientViews {
+	return &clientViews{
+		ttl:   ttl,
+		now:   time.Now,
+		views: make(map[viewKey]map[uint64]ClientView),
+		used:  make(map[viewKey]time.Time),
+	}
+}
+
+func (c *clientViews) GetClientView(spaceID string, clientGroupID string, cookie uint64) (ClientView, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	view, ok := c.views[viewKey{spaceID, clientGroupID}][cookie]
+	return view, ok, nil
+}
+
+func (c *clientViews) GetLatestCookie(spaceID string, clientGroupID string) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var latest uint64
+	for cookie := range c.views[viewKey{spaceID, clientGroupID}] {
+		latest = max(latest, cookie)
+	}
+	return latest, nil
+}
+
+func (c *clientViews) PutClientView(spaceID string, clientGroupID string, view ClientView) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := viewKey{spaceID, clientGroupID}
+	if c.views[key] == nil {
+		c.views[key] = make(map[uint64]ClientView)
+	}
+	c.views[key][view.Cookie] = view
+	c.use(key)
+	return nil
+}
+
+func (c *clientViews) PruneClientViews(spaceID string, clientGroupID string, cookie uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := viewKey{spaceID, clientGroupID}
+	views, ok := c.views[key]
+	if !ok {
+		return nil
+	}
+	for older := range views {
+		if older < cookie {
+			delete(views, older)
+		}
+	}
+	c.use(key)
+	return nil
+}
+
+// use records that the views of key were used, and drops the views unused
+// for ttl, at most once every ttl. c.mu must be held.
+func (c *clientViews) use(key viewKey) {
+	now := c.now()
+	c.used[key] = now
+	if c.ttl <= 0 || now.Sub(c.swept) < c.ttl {
+		return
+	}
+
+	c.swept = now
+	cutoff := now.Add(-c.ttl)
+	for key, used := range c.used {
+		if used.Before(cutoff) {
+			delete(c.views, key)
+			delete(c.used, key)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package replicache_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/bolt"
+	"github.com/airheartdev/replicache/memory"
+)
+
+// withRowVersioning uses row versioning, authenticating each request as the
+// user named by its token.
+func (s *SyncSuite) withRowVersioning(options ...replicache.RowVersioningOption[string]) {
+	s.setup(replicache.WithAuth(func(ctx context.Context, token string) (any, bool) {
+		return token, token != ""
+	}))
+	s.rep.SetStrategy(replicache.RowVersioning(options...))
+}
+
+// pullAs pulls space-1 as user and returns the keys put and deleted.
+func (s *SyncSuite) pullAs(user string, cookie uint64) (resp replicache.PullResponse[string], puts []string, dels []string) {
+	w := s.postAs(user, replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: user + "-client", Cookie: cookie})
+	s.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	s.Require().NoError(json.NewDecoder(w.Body).Decode(&resp))
+
+	for _, op := range resp.Patch {
+		switch op.Op {
+		case replicache.PatchPut:
+			puts = append(puts, *op.Key)
+		case replicache.PatchDel:
+			dels = append(dels, *op.Key)
+		}
+	}
+	sort.Strings(puts)
+	sort.Strings(dels)
+	return resp, puts, dels
+}
+
+func (s *SyncSuite) transact(fn func(tx replicache.ReadWriteTransaction[string]) error) {
+	s.Require().NoError(s.rep.Transact(context.Background(), "space-1", fn))
+}
+
+func (s *SyncSuite) TestRowVersioningVisibility() {
+	// Users see their own todos, and todos shared with everyone.
+	s.withRowVersioning(replicache.WithVisibility(func(ctx context.Context, clientID string, entry *replicache.Entry[string]) bool {
+		user, _ := replicache.PrincipalFromContext(ctx)
+		return strings.HasPrefix(entry.Key, "todo/"+user.(string)+"/") || entry.Value == "shared"
+	}))
+
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		a, b := "alice's", "bob's"
+		tx.Put("todo/alice/1", &a)
+		return tx.Put("todo/bob/1", &b)
+	})
+
+	resp, puts, _ := s.pullAs("alice", 0)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+	s.Equal([]string{"todo/alice/1"}, puts)
+	aliceCookie := resp.Cookie
+
+	resp, puts, _ = s.pullAs("bob", 0)
+	s.Equal([]string{"todo/bob/1"}, puts)
+	bobCookie := resp.Cookie
+
+	// Changes to rows bob can't see don't reach him.
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "alice's, edited"
+		return tx.Put("todo/alice/1", &v)
+	})
+	resp, puts, dels := s.pullAs("bob", bobCookie)
+	s.Empty(resp.Patch)
+	s.Equal(bobCookie, resp.Cookie)
+	s.Empty(puts)
+	s.Empty(dels)
+
+	// A row which becomes visible is put, and one which stops being
+	// visible is deleted.
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "shared"
+		return tx.Put("todo/alice/1", &v)
+	})
+	resp, puts, _ = s.pullAs("bob", bobCookie)
+	s.Equal([]string{"todo/alice/1"}, puts)
+	bobCookie = resp.Cookie
+
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "private again"
+		return tx.Put("todo/alice/1", &v)
+	})
+	_, puts, dels = s.pullAs("bob", bobCookie)
+	s.Empty(puts)
+	s.Equal([]string{"todo/alice/1"}, dels)
+
+	_, puts, dels = s.pullAs("alice", aliceCookie)
+	s.Equal([]string{"todo/alice/1"}, puts)
+	s.Empty(dels)
+}
+
+func (s *SyncSuite) TestRowVersioningClientViewStore() {
+	views, err := bolt.Open[string](filepath.Join(s.T().TempDir(), "views.db"))
+	s.Require().NoError(err)
+	defer views.Close()
+
+	s.withRowVersioning(replicache.WithClientViewStore[string](views))
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "one"
+		return tx.Put("todo/alice/1", &v)
+	})
+	resp, _, _ := s.pullAs("alice", 0)
+	cookie := resp.Cookie
+
+	// A new strategy, as after a restart, picks up from the stored view.
+	s.rep.SetStrategy(replicache.RowVersioning(replicache.WithClientViewStore[string](views)))
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "two"
+		return tx.Put("todo/alice/2", &v)
+	})
+	resp, puts, _ := s.pullAs("alice", cookie)
+	s.Require().NotEmpty(resp.Patch)
+	s.NotEqual(replicache.PatchClear, resp.Patch[0].Op)
+	s.Equal([]string{"todo/alice/2"}, puts)
+	s.Greater(resp.Cookie, cookie)
+}
+
+func (s *SyncSuite) TestRowVersioningAfterReset() {
+	s.withRowVersioning()
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "one"
+		return tx.Put("todo/alice/1", &v)
+	})
+	resp, _, _ := s.pullAs("alice", 0)
+
+	// The views of a deleted client, or of a reset space, are dropped with
+	// it, so the next pull is a snapshot.
+	s.Require().NoError(s.store.DeleteClient("alice-client"))
+	resp, _, _ = s.pullAs("alice", resp.Cookie)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+
+	s.Require().NoError(s.store.ResetSpace("space-1", 1))
+	resp, _, _ = s.pullAs("alice", resp.Cookie)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+}
+
+// unreadable is a store whose entries can't be read.
+type unreadable struct {
+	*memory.MemoryBackend[string]
+}
+
+func (unreadable) GetEntries(spaceID string, fromKey string) ([]*replicache.Entry[string], error) {
+	return nil, errors.New("disk on fire")
+}
+
+func (s *SyncSuite) TestRowVersioningReadError() {
+	s.withRowVersioning()
+	s.transact(func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "one"
+		return tx.Put("todo/alice/1", &v)
+	})
+	resp, _, _ := s.pullAs("alice", 0)
+
+	// A failed read fails the pull, rather than deleting every row.
+	s.rep.SetStore(unreadable{s.store})
+	w := s.postAs("alice", replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "alice-client", Cookie: resp.Cookie})
+	s.Equal(http.StatusInternalServerError, w.Code)
+}
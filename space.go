@@ -0,0 +1,94 @@
+package replicache
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SpaceResolver extracts the space ID from an incoming push or pull request.
+// The request context carries the authenticated principal.
+type SpaceResolver func(req *http.Request) (string, error)
+
+var ErrSpaceRequired = errors.New("space ID is required")
+
+// SpaceFromQuery reads the space ID from the named query parameter.
+func SpaceFromQuery(name string) SpaceResolver {
+	return func(req *http.Request) (string, error) {
+		return req.URL.Query().Get(name), nil
+	}
+}
+
+// SpaceFromHeader reads the space ID from the named request header.
+func SpaceFromHeader(name string) SpaceResolver {
+	return func(req *http.Request) (string, error) {
+		return req.Header.Get(name), nil
+	}
+}
+
+// SpaceFromPathValue reads the space ID from a http.ServeMux path wildcard,
+// e.g. "POST /replicache-push/{spaceID}".
+func SpaceFromPathValue(name string) SpaceResolver {
+	return func(req *http.Request) (string, error) {
+		return req.PathValue(name), nil
+	}
+}
+
+// SpaceFromURLParam reads the space ID from a chi route parameter.
+func SpaceFromURLParam(name string) SpaceResolver {
+	return func(req *http.Request) (string, error) {
+		return chi.URLParam(req, name), nil
+	}
+}
+
+// SpaceFromPrincipal derives the space ID from the authenticated principal.
+func SpaceFromPrincipal(fn func(principal any) (string, error)) SpaceResolver {
+	return func(req *http.Request) (string, error) {
+		principal, _ := PrincipalFromContext(req.Context())
+		return fn(principal)
+	}
+}
+
+// SpaceFromFirst returns the first non-empty space ID found by resolvers. The
+// default resolver checks the "spaceID" path value, chi URL parameter and
+// query parameter in turn.
+func SpaceFromFirst(resolvers ...SpaceResolver) SpaceResolver {
+	return func(req *http.Request) (string, error) {
+		for _, resolve := range resolvers {
+			spaceID, err := resolve(req)
+			if err != nil || spaceID != "" {
+				return spaceID, err
+			}
+		}
+		return "", nil
+	}
+}
+
+// WithSpaceResolver sets how the space ID is extracted from requests.
+func WithSpaceResolver(fn SpaceResolver) Option {
+	return func(o *Options) {
+		o.spaceResolver = fn
+	}
+}
+
+// WithRequiredSpace rejects push and pull requests which don't resolve to a
+// space ID.
+func WithRequiredSpace() Option {
+	return func(o *Options) {
+		o.requireSpace = true
+	}
+}
+
+func (r *Replicache[T]) resolveSpace(w http.ResponseWriter, req *http.Request) (string, bool) {
+	spaceID, err := r.options.spaceResolver(req)
+	if err == nil && spaceID == "" && r.options.requireSpace {
+		err = ErrSpaceRequired
+	}
+	if err != nil {
+		writeError(req.Context(), w, http.StatusBadRequest, err)
+		return "", false
+	}
+
+	return spaceID, true
+}
@@ -0,0 +1,191 @@
+// Package sqlstore implements Replicache stores on database/sql.
+//
+// It does not import a driver. Open the *sql.DB with the driver of your
+// database and pick the Dialect matching its placeholder syntax.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/airheartdev/replicache"
+)
+
+type (
+	// Dialect describes the SQL accepted by a database.
+	Dialect struct {
+		// Placeholder returns the placeholder for the nth argument, from 1.
+		Placeholder func(n int) string
+		// Blob is the column type of binary values.
+		Blob string
+	}
+
+	// MutationLog is a replicache.MutationLog stored in a table. Timestamps are
+	// stored as Unix nanoseconds so the schema is portable.
+	MutationLog struct {
+		db      *sql.DB
+		table   string
+		dialect Dialect
+	}
+
+	Option func(l *MutationLog)
+)
+
+var (
+	// SQLite and MySQL use ? placeholders.
+	SQLite = Dialect{Placeholder: func(int) string { return "?" }, Blob: "BLOB"}
+	MySQL  = SQLite
+	// Postgres uses numbered $n placeholders.
+	Postgres = Dialect{Placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }, Blob: "BYTEA"}
+)
+
+var _ replicache.MutationLog = &MutationLog{}
+
+// WithTable sets the table name. Defaults to replicache_mutations.
+func WithTable(name string) Option {
+	return func(l *MutationLog) {
+		l.table = name
+	}
+}
+
+// WithDialect sets the SQL dialect. Defaults to SQLite.
+func WithDialect(dialect Dialect) Option {
+	return func(l *MutationLog) {
+		l.dialect = dialect
+	}
+}
+
+func NewMutationLog(db *sql.DB, options ...Option) *MutationLog {
+	l := &MutationLog{
+		db:      db,
+		table:   "replicache_mutations",
+		dialect: SQLite,
+	}
+	for _, option := range options {
+		option(l)
+	}
+	return l
+}
+
+// CreateTable creates the table and its indexes if they do not exist.
+func (l *MutationLog) CreateTable(ctx context.Context) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + l.table + ` (
+			space_id    VARCHAR(255) NOT NULL,
+			client_id   VARCHAR(255) NOT NULL,
+			profile_id  VARCHAR(255) NOT NULL,
+			mutation_id BIGINT NOT NULL,
+			name        VARCHAR(255) NOT NULL,
+			args        TEXT NOT NULL,
+			timestamp   BIGINT NOT NULL,
+			version     BIGINT NOT NULL,
+			error       TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + l.table + `_space_idx ON ` + l.table + ` (space_id, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS ` + l.table + `_client_idx ON ` + l.table + ` (client_id, timestamp)`,
+	}
+	for _, stmt := range statements {
+		if _, err := l.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Append inserts records in a single transaction.
+func (l *MutationLog) Append(ctx context.Context, records ...replicache.MutationRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	placeholders := make([]string, 9)
+	for i := range placeholders {
+		placeholders[i] = l.dialect.Placeholder(i + 1)
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO `+l.table+
+		` (space_id, client_id, profile_id, mutation_id, name, args, timestamp, version, error)`+
+		` VALUES (`+strings.Join(placeholders, ", ")+`)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rec := range records {
+		args := string(rec.Args)
+		if args == "" {
+			args = "null"
+		}
+		_, err := stmt.ExecContext(ctx,
+			rec.SpaceID, rec.ClientID, rec.ProfileID, int64(rec.MutationID), rec.Name,
+			args, rec.Timestamp.UnixNano(), int64(rec.Version), rec.Error)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (l *MutationLog) Query(ctx context.Context, q replicache.MutationQuery) ([]replicache.MutationRecord, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, cond+" "+l.dialect.Placeholder(len(args)))
+	}
+
+	if q.SpaceID != "" {
+		add("space_id =", q.SpaceID)
+	}
+	if q.ClientID != "" {
+		add("client_id =", q.ClientID)
+	}
+	if !q.Since.IsZero() {
+		add("timestamp >=", q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		add("timestamp <", q.Until.UnixNano())
+	}
+
+	query := `SELECT space_id, client_id, profile_id, mutation_id, name, args, timestamp, version, error FROM ` + l.table
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY timestamp, mutation_id`
+	if q.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, q.Limit)
+	}
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]replicache.MutationRecord, 0)
+	for rows.Next() {
+		var rec replicache.MutationRecord
+		var mutationID, timestamp, version int64
+		var recArgs string
+		err := rows.Scan(&rec.SpaceID, &rec.ClientID, &rec.ProfileID, &mutationID, &rec.Name,
+			&recArgs, &timestamp, &version, &rec.Error)
+		if err != nil {
+			return nil, err
+		}
+		rec.MutationID = uint64(mutationID)
+		rec.Version = uint64(version)
+		rec.Args = []byte(recArgs)
+		rec.Timestamp = time.Unix(0, timestamp)
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
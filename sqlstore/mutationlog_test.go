@@ -0,0 +1,50 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func TestMutationLog(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "log.db"))
+	r.NoError(err)
+	defer db.Close()
+
+	log := NewMutationLog(db)
+	r.NoError(log.CreateTable(ctx))
+	r.NoError(log.Append(ctx,
+		replicache.MutationRecord{SpaceID: "space-1", ClientID: "client-1", MutationID: 1, Name: "put", Args: json.RawMessage(`{"a":1}`), Timestamp: now, Version: 3},
+		replicache.MutationRecord{SpaceID: "space-2", ClientID: "client-2", MutationID: 1, Timestamp: now.Add(time.Second), Error: "boom"},
+		replicache.MutationRecord{SpaceID: "space-1", ClientID: "client-1", MutationID: 2, Timestamp: now.Add(2 * time.Second)},
+	))
+
+	records, err := replicache.MutationsBySpace(ctx, log, "space-1")
+	r.NoError(err)
+	r.Len(records, 2)
+	r.Equal("put", records[0].Name)
+	r.JSONEq(`{"a":1}`, string(records[0].Args))
+	r.Equal(uint64(3), records[0].Version)
+	r.True(now.Equal(records[0].Timestamp))
+
+	records, err = replicache.MutationsBetween(ctx, log, now.Add(time.Second), now.Add(2*time.Second))
+	r.NoError(err)
+	r.Len(records, 1)
+	r.Equal("boom", records[0].Error)
+
+	records, err = log.Query(ctx, replicache.MutationQuery{ClientID: "client-1", Limit: 1})
+	r.NoError(err)
+	r.Len(records, 1)
+	r.Equal(uint64(1), records[0].MutationID)
+}
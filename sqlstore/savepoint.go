@@ -0,0 +1,50 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/airheartdev/replicache"
+)
+
+// Savepoints implements replicache.Savepointer with SQL savepoints. It is
+// embedded in Transaction, and in any other transaction which writes
+// through to a *sql.Tx, so Push can skip or retry a failed mutation without
+// aborting the push.
+type Savepoints struct {
+	tx *sql.Tx
+	n  int
+}
+
+var _ replicache.Savepointer = &Savepoints{}
+
+func NewSavepoints(tx *sql.Tx) *Savepoints {
+	return &Savepoints{tx: tx}
+}
+
+// Savepoint issues a SAVEPOINT.
+func (s *Savepoints) Savepoint(ctx context.Context) (replicache.Savepoint, error) {
+	if _, err := s.tx.ExecContext(ctx, "SAVEPOINT "+savepointName(s.n+1)); err != nil {
+		return 0, err
+	}
+	s.n++
+	return replicache.Savepoint(s.n), nil
+}
+
+// RollbackTo issues a ROLLBACK TO SAVEPOINT, which keeps sp and releases
+// the savepoints after it.
+func (s *Savepoints) RollbackTo(ctx context.Context, sp replicache.Savepoint) error {
+	if sp < 1 || int(sp) > s.n {
+		return replicache.ErrInvalidSavepoint
+	}
+	if _, err := s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepointName(int(sp))); err != nil {
+		return err
+	}
+	s.n = int(sp)
+	return nil
+}
+
+func savepointName(n int) string {
+	return fmt.Sprintf("replicache_sp_%d", n)
+}
@@ -0,0 +1,59 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavepoints(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "savepoints.db"))
+	r.NoError(err)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE entries (key TEXT PRIMARY KEY)`)
+	r.NoError(err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	r.NoError(err)
+	defer tx.Rollback()
+
+	count := func() int {
+		var n int
+		r.NoError(tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM entries`).Scan(&n))
+		return n
+	}
+	insert := func(key string) {
+		_, err := tx.ExecContext(ctx, `INSERT INTO entries (key) VALUES (?)`, key)
+		r.NoError(err)
+	}
+
+	s := NewSavepoints(tx)
+	insert("todo/1")
+	sp, err := s.Savepoint(ctx)
+	r.NoError(err)
+
+	insert("todo/2")
+	inner, err := s.Savepoint(ctx)
+	r.NoError(err)
+	insert("todo/3")
+	r.Equal(3, count())
+
+	r.NoError(s.RollbackTo(ctx, sp))
+	r.Equal(1, count())
+	r.ErrorIs(s.RollbackTo(ctx, inner), replicache.ErrInvalidSavepoint)
+
+	// sp survives the rollback.
+	insert("todo/4")
+	r.NoError(s.RollbackTo(ctx, sp))
+	r.Equal(1, count())
+
+	r.NoError(tx.Commit())
+}
@@ -0,0 +1,267 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/airheartdev/replicache"
+)
+
+type (
+	// Transaction is a replicache.ReadWriteTransaction which stages its
+	// writes in a temporary table of a *sql.Tx, so the Savepoints it embeds
+	// undo them and Push can skip or retry a failed mutation without
+	// aborting the push. Reads of keys it hasn't written go to the backend.
+	//
+	// Keys are staged in a VARCHAR(255) column, so longer keys fail on
+	// databases which enforce the length.
+	Transaction[T any] struct {
+		*Savepoints
+		ctx     context.Context
+		tx      *sql.Tx
+		table   string
+		dialect Dialect
+		codec   replicache.Codec[T]
+		backend replicache.Backend[T]
+		spaceID string
+		version uint64
+		// err is the first error reading the staged writes, which Changes
+		// can't return.
+		err error
+	}
+
+	TransactionOption func(o *transactionOptions)
+
+	transactionOptions struct {
+		table   string
+		dialect Dialect
+		codec   any
+	}
+)
+
+var (
+	_ replicache.ReadWriteTransaction[any] = &Transaction[any]{}
+	_ replicache.Savepointer               = &Transaction[any]{}
+)
+
+// WithStagingTable sets the name of the temporary table writes are staged
+// in. Defaults to replicache_staged.
+func WithStagingTable(name string) TransactionOption {
+	return func(o *transactionOptions) {
+		o.table = name
+	}
+}
+
+// WithStagingDialect sets the SQL dialect of the transaction. Defaults to
+// SQLite.
+func WithStagingDialect(dialect Dialect) TransactionOption {
+	return func(o *transactionOptions) {
+		o.dialect = dialect
+	}
+}
+
+// WithStagingCodec sets the Codec used to encode staged values. It must be a
+// Codec for the value type of the transaction. Defaults to
+// replicache.JSONCodec.
+func WithStagingCodec[T any](codec replicache.Codec[T]) TransactionOption {
+	return func(o *transactionOptions) {
+		o.codec = codec
+	}
+}
+
+// NewTransaction returns a transaction on spaceID at version which stages
+// its writes in tx, creating the staging table if it doesn't exist and
+// emptying it. ctx is used for every statement. The caller ends tx once the
+// changes are committed.
+func NewTransaction[T any](ctx context.Context, tx *sql.Tx, backend replicache.Backend[T], spaceID string, version uint64, options ...TransactionOption) (*Transaction[T], error) {
+	opts := transactionOptions{
+		table:   "replicache_staged",
+		dialect: SQLite,
+		codec:   replicache.JSONCodec[T]{},
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	codec, ok := opts.codec.(replicache.Codec[T])
+	if !ok {
+		return nil, fmt.Errorf("codec %T does not encode %T", opts.codec, *new(T))
+	}
+
+	statements := []string{
+		`CREATE TEMPORARY TABLE IF NOT EXISTS ` + opts.table + ` (
+			entry_key   VARCHAR(255) NOT NULL PRIMARY KEY,
+			entry_value ` + opts.dialect.Blob + `,
+			deleted     INTEGER NOT NULL
+		)`,
+		`DELETE FROM ` + opts.table,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Transaction[T]{
+		Savepoints: NewSavepoints(tx),
+		ctx:        ctx,
+		tx:         tx,
+		table:      opts.table,
+		dialect:    opts.dialect,
+		codec:      codec,
+		backend:    backend,
+		spaceID:    spaceID,
+		version:    version,
+	}, nil
+}
+
+// Get returns the staged value of key, or the value in the backend if key
+// wasn't written.
+func (t *Transaction[T]) Get(key string) (*T, error) {
+	var value []byte
+	var deleted int
+	err := t.tx.QueryRowContext(t.ctx,
+		`SELECT entry_value, deleted FROM `+t.table+` WHERE entry_key = `+t.dialect.Placeholder(1), key,
+	).Scan(&value, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t.backend.GetEntry(t.spaceID, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deleted != 0 {
+		return nil, replicache.ErrNotFound
+	}
+
+	v, err := t.codec.Unmarshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Has reports whether key was written and not deleted.
+func (t *Transaction[T]) Has(key string) bool {
+	var n int
+	err := t.tx.QueryRowContext(t.ctx,
+		`SELECT COUNT(*) FROM `+t.table+` WHERE deleted = 0 AND entry_key = `+t.dialect.Placeholder(1), key,
+	).Scan(&n)
+	return err == nil && n > 0
+}
+
+// IsEmpty reports whether nothing was written.
+func (t *Transaction[T]) IsEmpty() bool {
+	var n int
+	err := t.tx.QueryRowContext(t.ctx, `SELECT COUNT(*) FROM `+t.table).Scan(&n)
+	return err == nil && n == 0
+}
+
+// Put stages value for key. A nil value deletes key.
+func (t *Transaction[T]) Put(key string, value *T) error {
+	if value == nil {
+		return t.stage(key, nil, true)
+	}
+	data, err := t.codec.Marshal(*value)
+	if err != nil {
+		return err
+	}
+	return t.stage(key, data, false)
+}
+
+// Del stages a tombstone for key. The key need not exist.
+func (t *Transaction[T]) Del(key string) error {
+	return t.stage(key, nil, true)
+}
+
+// stage replaces the staged write of key. Upserts differ between databases,
+// so the old row is deleted first.
+func (t *Transaction[T]) stage(key string, value []byte, deleted bool) error {
+	_, err := t.tx.ExecContext(t.ctx, `DELETE FROM `+t.table+` WHERE entry_key = `+t.dialect.Placeholder(1), key)
+	if err != nil {
+		return err
+	}
+
+	flag := 0
+	if deleted {
+		flag = 1
+	}
+	_, err = t.tx.ExecContext(t.ctx,
+		`INSERT INTO `+t.table+` (entry_key, entry_value, deleted) VALUES (`+
+			t.dialect.Placeholder(1)+`, `+t.dialect.Placeholder(2)+`, `+t.dialect.Placeholder(3)+`)`,
+		key, value, flag)
+	return err
+}
+
+// Changes returns the staged writes in key order. If they can't be read it
+// returns none, and Flush returns the error.
+func (t *Transaction[T]) Changes() []replicache.Entry[T] {
+	entries, err := t.changes()
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return nil
+	}
+	return entries
+}
+
+func (t *Transaction[T]) changes() ([]replicache.Entry[T], error) {
+	rows, err := t.tx.QueryContext(t.ctx, `SELECT entry_key, entry_value, deleted FROM `+t.table+` ORDER BY entry_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	entries := make([]replicache.Entry[T], 0)
+	for rows.Next() {
+		var value []byte
+		var deleted int
+		entry := replicache.Entry[T]{
+			SpaceID:        t.spaceID,
+			Version:        t.version,
+			LastModifiedAt: now,
+		}
+		if err := rows.Scan(&entry.Key, &value, &deleted); err != nil {
+			return nil, err
+		}
+		entry.Deleted = deleted != 0
+		if !entry.Deleted {
+			if entry.Value, err = t.codec.Unmarshal(value); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Flush writes the staged changes to the backend. Deleting a key which
+// doesn't exist is not an error, as when the changes are committed.
+func (t *Transaction[T]) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	entries, err := t.changes()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Deleted {
+			err = t.backend.DelEntry(t.spaceID, entry.Key, t.version)
+			if errors.Is(err, replicache.ErrNotFound) {
+				err = nil
+			}
+		} else {
+			err = t.backend.PutEntry(t.spaceID, entry.Key, entry.Value, t.version)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// stagedStore is a memory store whose transactions stage their writes in
+// SQLite. Each is rolled back once its changes are committed.
+type stagedStore struct {
+	*memory.MemoryBackend[string]
+	t    *testing.T
+	db   *sql.DB
+	open *sql.Tx
+}
+
+func (s *stagedStore) Transaction(spaceID string, clientID string, version uint64) replicache.ReadWriteTransaction[string] {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	require.NoError(s.t, err)
+	s.open = tx
+
+	stx, err := NewTransaction[string](ctx, tx, s.MemoryBackend, spaceID, version)
+	require.NoError(s.t, err)
+	return stx
+}
+
+func (s *stagedStore) Commit(cs replicache.ChangeSet[string]) error {
+	defer s.open.Rollback()
+	return s.MemoryBackend.Commit(cs)
+}
+
+func newStagedReplicache(t *testing.T, policy replicache.FailurePolicy) (*replicache.Replicache[string], *memory.MemoryBackend[string]) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "staged.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	backend := memory.New[string]()
+	rep := replicache.New[string](replicache.WithFailurePolicy(policy))
+	rep.SetStore(&stagedStore{MemoryBackend: backend, t: t, db: db})
+	rep.Register("put", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		var args struct{ Key, Value string }
+		if err := json.Unmarshal(m.Args, &args); err != nil {
+			return err
+		}
+		return tx.Put(args.Key, &args.Value)
+	})
+	return rep, backend
+}
+
+func TestTransactionSkipOnFailure(t *testing.T) {
+	r := require.New(t)
+	rep, backend := newStagedReplicache(t, replicache.SkipOnFailure)
+	rep.Register("putThenFail", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		v := "partial"
+		r.NoError(tx.Put("todo/1", &v))
+		r.NoError(tx.Put("todo/2", &v))
+		return errors.New("boom")
+	})
+
+	err := rep.Push(context.Background(), &replicache.PushRequest{
+		ClientID: "client-1",
+		Mutations: []replicache.Mutation{
+			{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+			{ID: 2, Name: "putThenFail"},
+			{ID: 3, Name: "put", Args: json.RawMessage(`{"Key":"todo/3","Value":"three"}`)},
+		},
+	}, "space-1")
+	r.NoError(err)
+
+	lastMutationID, _, _ := backend.GetLastMutationID("client-1")
+	r.Equal(uint64(3), lastMutationID)
+	one, err := backend.GetEntry("space-1", "todo/1")
+	r.NoError(err)
+	r.Equal("one", *one)
+	_, err = backend.GetEntry("space-1", "todo/2")
+	r.ErrorIs(err, replicache.ErrNotFound)
+	_, err = backend.GetEntry("space-1", "todo/3")
+	r.NoError(err)
+}
+
+func TestTransactionRetryThenSkip(t *testing.T) {
+	r := require.New(t)
+	rep, backend := newStagedReplicache(t, replicache.RetryThenSkip(2))
+
+	attempts := 0
+	rep.Register("flaky", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		attempts++
+		// Each attempt sees none of the writes of the ones before it.
+		r.False(tx.Has("todo/flaky"))
+		v := "flaky"
+		r.NoError(tx.Put("todo/flaky", &v))
+		if attempts < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	err := rep.Push(context.Background(), &replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: []replicache.Mutation{{ID: 1, Name: "flaky"}},
+	}, "space-1")
+	r.NoError(err)
+	r.Equal(3, attempts)
+
+	flaky, err := backend.GetEntry("space-1", "todo/flaky")
+	r.NoError(err)
+	r.Equal("flaky", *flaky)
+}
+
+func TestTransaction(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "staged.db"))
+	r.NoError(err)
+	defer db.Close()
+
+	backend := memory.New[string]()
+	r.NoError(backend.PutEntry("space-1", "todo/1", "one", 1))
+	r.NoError(backend.PutEntry("space-1", "todo/2", "two", 1))
+
+	tx, err := db.BeginTx(ctx, nil)
+	r.NoError(err)
+	defer tx.Rollback()
+	stx, err := NewTransaction[string](ctx, tx, backend, "space-1", 2)
+	r.NoError(err)
+	r.True(stx.IsEmpty())
+
+	// Reads fall through to the backend until a key is written.
+	one, err := stx.Get("todo/1")
+	r.NoError(err)
+	r.Equal("one", *one)
+
+	v := "uno"
+	r.NoError(stx.Put("todo/1", &v))
+	r.NoError(stx.Del("todo/2"))
+	one, err = stx.Get("todo/1")
+	r.NoError(err)
+	r.Equal("uno", *one)
+	_, err = stx.Get("todo/2")
+	r.ErrorIs(err, replicache.ErrNotFound)
+	r.False(stx.Has("todo/2"))
+
+	changes := stx.Changes()
+	r.Len(changes, 2)
+	r.Equal(replicache.Entry[string]{SpaceID: "space-1", Key: "todo/1", Value: "uno", Version: 2, LastModifiedAt: changes[0].LastModifiedAt}, changes[0])
+	r.True(changes[1].Deleted)
+
+	r.NoError(stx.Flush())
+	one, err = backend.GetEntry("space-1", "todo/1")
+	r.NoError(err)
+	r.Equal("uno", *one)
+	_, err = backend.GetEntry("space-1", "todo/2")
+	r.ErrorIs(err, replicache.ErrNotFound)
+}
@@ -0,0 +1,167 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrGlobalVersionUnsupported = errors.New("global versioning requires a store implementing AdminStore")
+
+type (
+	// SyncStrategy decides the versions changes are committed at, and how a
+	// pull is answered from the cookie the client holds. Its methods are
+	// called with the Replicache lock held.
+	SyncStrategy[T any] interface {
+		// NextVersion returns the version to commit the next change set to
+		// spaceID at.
+		NextVersion(store Store[T], spaceID string) (uint64, error)
+		// Pull returns the cookie and patch answering pr.
+		Pull(ctx context.Context, store Store[T], pr *PullRequest, spaceID string) (uint64, []PatchOperation[T], error)
+	}
+
+	perSpaceVersion[T any] struct{}
+
+	globalVersion[T any] struct {
+		mu      sync.Mutex
+		version uint64
+		loaded  bool
+	}
+
+	alwaysReset[T any] struct {
+		perSpaceVersion[T]
+	}
+)
+
+// SetStrategy sets the sync strategy used by Push, Pull and Transact. The
+// default is PerSpaceVersion.
+func (r *Replicache[T]) SetStrategy(strategy SyncStrategy[T]) {
+	r.strategy = strategy
+}
+
+// PerSpaceVersion versions each space separately. The cookie is the version
+// of the space, and a pull returns the entries changed since.
+func PerSpaceVersion[T any]() SyncStrategy[T] {
+	return perSpaceVersion[T]{}
+}
+
+func (perSpaceVersion[T]) NextVersion(store Store[T], spaceID string) (uint64, error) {
+	version, err := store.GetCookie(spaceID)
+	if err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+func (perSpaceVersion[T]) Pull(ctx context.Context, store Store[T], pr *PullRequest, spaceID string) (uint64, []PatchOperation[T], error) {
+	cookie, err := store.GetCookie(spaceID)
+	if err != nil {
+		return 0, nil, err
+	}
+	patch, err := changesSince(store, spaceID, pr.Cookie)
+	return cookie, patch, err
+}
+
+// GlobalVersion shares one version between all spaces, so a cookie orders
+// changes across spaces. The version is read from the store's spaces on
+// first use and then kept in memory, so the store must implement
+// AdminStore and must not be written by other Replicache instances.
+func GlobalVersion[T any]() SyncStrategy[T] {
+	return &globalVersion[T]{}
+}
+
+func (g *globalVersion[T]) NextVersion(store Store[T], spaceID string) (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.load(store); err != nil {
+		return 0, err
+	}
+	g.version++
+	return g.version, nil
+}
+
+func (g *globalVersion[T]) Pull(ctx context.Context, store Store[T], pr *PullRequest, spaceID string) (uint64, []PatchOperation[T], error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.load(store); err != nil {
+		return 0, nil, err
+	}
+	patch, err := changesSince(store, spaceID, pr.Cookie)
+	return g.version, patch, err
+}
+
+// load reads the newest version of any space. g.mu must be held.
+func (g *globalVersion[T]) load(store Store[T]) error {
+	if g.loaded {
+		return nil
+	}
+
+	admin, ok := store.(AdminStore)
+	if !ok {
+		return ErrGlobalVersionUnsupported
+	}
+	spaces, err := admin.ListSpaces()
+	if err != nil {
+		return err
+	}
+	for _, space := range spaces {
+		g.version = max(g.version, space.Version)
+	}
+	g.loaded = true
+	return nil
+}
+
+// AlwaysReset versions spaces as PerSpaceVersion does, but answers every
+// pull with a clear and a full snapshot. It suits small spaces, and stores
+// which keep no tombstones.
+func AlwaysReset[T any]() SyncStrategy[T] {
+	return alwaysReset[T]{}
+}
+
+func (alwaysReset[T]) Pull(ctx context.Context, store Store[T], pr *PullRequest, spaceID string) (uint64, []PatchOperation[T], error) {
+	cookie, err := store.GetCookie(spaceID)
+	if err != nil {
+		return 0, nil, err
+	}
+	patch, err := changesSince(store, spaceID, 0)
+	return cookie, patch, err
+}
+
+// changesSince returns the patch of entries of spaceID changed since cookie.
+// A cookie of 0, or one below the floor of the store, gets a clear and a
+// full snapshot instead.
+func changesSince[T any](store Store[T], spaceID string, cookie uint64) ([]PatchOperation[T], error) {
+	if floor, ok := store.(CookieFloor); ok {
+		minCookie, err := floor.GetMinCookie(spaceID)
+		if err != nil {
+			return nil, err
+		}
+		if cookie < minCookie {
+			cookie = 0
+		}
+	}
+
+	patch := []PatchOperation[T]{}
+	if cookie == 0 {
+		patch = append(patch, PatchOperation[T]{Op: PatchClear})
+	}
+
+	entries, err := store.GetChangedEntries(spaceID, cookie)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		key := entry.Key
+		if entry.Deleted {
+			// Deletes are redundant after a clear.
+			if cookie != 0 {
+				patch = append(patch, PatchOperation[T]{Op: PatchDel, Key: &key})
+			}
+		} else {
+			patch = append(patch, PatchOperation[T]{Op: PatchPut, Key: &key, Value: &entry.Value})
+		}
+	}
+	return patch, nil
+}
@@ -0,0 +1,242 @@
+package replicache_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/stretchr/testify/suite"
+)
+
+// StrategySuite runs the same protocol tests against each sync strategy.
+type StrategySuite struct {
+	fixture
+	newStrategy func() replicache.SyncStrategy[string]
+	mutationIDs map[string]uint64
+}
+
+func TestStrategies(t *testing.T) {
+	strategies := map[string]func() replicache.SyncStrategy[string]{
+		"PerSpaceVersion": replicache.PerSpaceVersion[string],
+		"GlobalVersion":   replicache.GlobalVersion[string],
+		"AlwaysReset":     replicache.AlwaysReset[string],
+		"RowVersioning": func() replicache.SyncStrategy[string] {
+			return replicache.RowVersioning[string]()
+		},
+	}
+	for name, newStrategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			suite.Run(t, &StrategySuite{newStrategy: newStrategy})
+		})
+	}
+}
+
+func (s *StrategySuite) SetupTest() {
+	s.setup()
+	s.rep.SetStrategy(s.newStrategy())
+	s.mutationIDs = make(map[string]uint64)
+}
+
+// replica is the state of a client, built only from pull responses.
+type replica struct {
+	clientID       string
+	spaceID        string
+	cookie         uint64
+	lastMutationID uint64
+	entries        map[string]string
+}
+
+func newReplica(clientID, spaceID string) *replica {
+	return &replica{clientID: clientID, spaceID: spaceID, entries: make(map[string]string)}
+}
+
+func (s *StrategySuite) put(c *replica, key, value string) {
+	args, _ := json.Marshal(map[string]string{"Key": key, "Value": value})
+	s.mutate(c, "put", args)
+}
+
+func (s *StrategySuite) del(c *replica, key string) {
+	args, _ := json.Marshal(key)
+	s.mutate(c, "del", args)
+}
+
+func (s *StrategySuite) mutate(c *replica, name string, args json.RawMessage) {
+	s.mutationIDs[c.clientID]++
+	w := s.post(replicache.DefaultPushEndpoint+"/"+c.spaceID, replicache.PushRequest{
+		ClientID:  c.clientID,
+		Mutations: []replicache.Mutation{{ID: s.mutationIDs[c.clientID], Name: name, Args: args}},
+	})
+	s.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+}
+
+// sync pulls into c and returns the patch applied.
+func (s *StrategySuite) sync(c *replica) []replicache.PatchOperation[string] {
+	w := s.post(replicache.DefaultPullEndpoint+"/"+c.spaceID, replicache.PullRequest{
+		ClientID:       c.clientID,
+		Cookie:         c.cookie,
+		LastMutationID: c.lastMutationID,
+	})
+	s.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+
+	var resp replicache.PullResponse[string]
+	s.Require().NoError(json.NewDecoder(w.Body).Decode(&resp))
+
+	for _, op := range resp.Patch {
+		switch op.Op {
+		case replicache.PatchClear:
+			c.entries = make(map[string]string)
+		case replicache.PatchPut:
+			c.entries[*op.Key] = *op.Value
+		case replicache.PatchDel:
+			delete(c.entries, *op.Key)
+		}
+	}
+	c.cookie = resp.Cookie
+	c.lastMutationID = resp.LastMutationID
+	return resp.Patch
+}
+
+func (s *StrategySuite) TestConverges() {
+	alice := newReplica("alice", "space-1")
+	s.put(alice, "todo/1", "one")
+	s.put(alice, "todo/2", "two")
+	s.sync(alice)
+	s.Equal(map[string]string{"todo/1": "one", "todo/2": "two"}, alice.entries)
+	s.Equal(uint64(2), alice.lastMutationID)
+
+	s.del(alice, "todo/1")
+	s.put(alice, "todo/2", "deux")
+	s.put(alice, "todo/3", "three")
+	s.sync(alice)
+	s.Equal(map[string]string{"todo/2": "deux", "todo/3": "three"}, alice.entries)
+	s.Equal(uint64(5), alice.lastMutationID)
+
+	cookie := alice.cookie
+	s.sync(alice)
+	s.Equal(map[string]string{"todo/2": "deux", "todo/3": "three"}, alice.entries)
+	s.GreaterOrEqual(alice.cookie, cookie)
+}
+
+func (s *StrategySuite) TestClientsConverge() {
+	alice := newReplica("alice", "space-1")
+	bob := newReplica("bob", "space-1")
+
+	s.put(alice, "todo/1", "one")
+	s.sync(bob)
+	s.put(bob, "todo/2", "two")
+	s.del(bob, "todo/1")
+	s.sync(alice)
+	s.sync(bob)
+
+	s.Equal(map[string]string{"todo/2": "two"}, alice.entries)
+	s.Equal(alice.entries, bob.entries)
+	s.Equal(uint64(1), alice.lastMutationID)
+	s.Equal(uint64(2), bob.lastMutationID)
+}
+
+func (s *StrategySuite) TestSpacesAreSeparate() {
+	alice := newReplica("alice", "space-1")
+	bob := newReplica("bob", "space-2")
+
+	s.put(alice, "todo/1", "one")
+	s.put(bob, "todo/1", "uno")
+	s.sync(alice)
+	s.put(bob, "todo/2", "dos")
+	s.sync(alice)
+	s.sync(bob)
+
+	s.Equal(map[string]string{"todo/1": "one"}, alice.entries)
+	s.Equal(map[string]string{"todo/1": "uno", "todo/2": "dos"}, bob.entries)
+}
+
+func (s *StrategySuite) TestTransact() {
+	alice := newReplica("alice", "space-1")
+	s.sync(alice)
+
+	err := s.rep.Transact(context.Background(), "space-1", func(tx replicache.ReadWriteTransaction[string]) error {
+		v := "server"
+		return tx.Put("todo/1", &v)
+	})
+	s.Require().NoError(err)
+
+	s.sync(alice)
+	s.Equal(map[string]string{"todo/1": "server"}, alice.entries)
+	s.Equal(uint64(0), alice.lastMutationID)
+}
+
+func (s *StrategySuite) TestConvergesAfterCompaction() {
+	alice := newReplica("alice", "space-1")
+	bob := newReplica("bob", "space-1")
+
+	s.put(alice, "todo/1", "one")
+	s.put(alice, "todo/2", "two")
+	s.sync(bob)
+
+	s.del(alice, "todo/1")
+	_, err := s.store.Compact(0)
+	s.Require().NoError(err)
+
+	s.sync(bob)
+	s.Equal(map[string]string{"todo/2": "two"}, bob.entries)
+}
+
+func (s *StrategySuite) TestRetriedPull() {
+	alice := newReplica("alice", "space-1")
+	s.put(alice, "todo/1", "one")
+	s.sync(alice)
+
+	// A client which lost a response pulls again from its old cookie.
+	stale := *alice
+	stale.entries = map[string]string{"todo/1": "one"}
+	s.put(alice, "todo/2", "two")
+	s.sync(alice)
+	s.sync(&stale)
+
+	s.Equal(alice.entries, stale.entries)
+}
+
+func (s *SyncSuite) TestGlobalVersionCookie() {
+	s.rep.SetStrategy(replicache.GlobalVersion[string]())
+
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)})
+	s.push("space-2", replicache.Mutation{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"uno"}`)})
+
+	// The cookie of space-1 moves with writes to space-2.
+	resp := s.pull(replicache.DefaultPullEndpoint+"/space-1", 0)
+	s.Equal(uint64(2), resp.Cookie)
+	s.Len(resp.Patch, 2)
+
+	version, _ := s.store.GetCookie("space-2")
+	s.Equal(uint64(2), version)
+}
+
+func (s *SyncSuite) TestAlwaysResetPull() {
+	s.rep.SetStrategy(replicache.AlwaysReset[string]())
+
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)})
+	resp := s.pull(replicache.DefaultPullEndpoint+"/space-1", 1)
+	s.Equal(uint64(1), resp.Cookie)
+	s.Require().Len(resp.Patch, 2)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+}
+
+func (s *SyncSuite) TestRowVersioningPull() {
+	s.rep.SetStrategy(replicache.RowVersioning[string]())
+
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)})
+	resp := s.pull(replicache.DefaultPullEndpoint+"/space-1", 0)
+	s.Equal(uint64(1), resp.Cookie)
+	s.Len(resp.Patch, 2)
+
+	// Nothing changed, so the cookie is kept.
+	resp = s.pull(replicache.DefaultPullEndpoint+"/space-1", 1)
+	s.Equal(uint64(1), resp.Cookie)
+	s.Empty(resp.Patch)
+
+	// An unknown cookie is answered with a snapshot and a newer cookie.
+	resp = s.pull(replicache.DefaultPullEndpoint+"/space-1", 7)
+	s.Equal(uint64(8), resp.Cookie)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+}
@@ -0,0 +1,282 @@
+package replicache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SetStore sets the store used by Push and Pull.
+func (r *Replicache[T]) SetStore(store Store[T]) {
+	r.store = store
+}
+
+// Push applies the mutations in pr to spaceID using the registered mutators,
+// then pokes the clients of the space. It can be passed to HandlePush.
+//
+// A client unknown to the store which pushes anything but its first mutation
+// has lost its server state, and ErrClientStateNotFound is returned.
+func (r *Replicache[T]) Push(ctx context.Context, pr *PushRequest, spaceID string) error {
+	if r.store == nil {
+		return ErrNoStore
+	}
+	if err := r.runBeforePush(ctx, pr, spaceID); err != nil {
+		return err
+	}
+
+	// The mutation log may be remote, so it is appended to after the lock
+	// is released. Concurrent pushes may then append out of order, so logs
+	// order records by their timestamp, taken under the lock.
+	records, err := r.push(ctx, pr, spaceID)
+	r.logMutations(ctx, records...)
+	return err
+}
+
+// push applies and commits the mutations of pr, and returns the records for
+// the mutation log. It holds r.mu throughout.
+func (r *Replicache[T]) push(ctx context.Context, pr *PushRequest, spaceID string) ([]MutationRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The commit must not be cut short by the deadline, so mutations run
+	// under mctx rather than ctx.
+	mctx := ctx
+	if r.options.pushTimeout > 0 {
+		var cancel context.CancelFunc
+		mctx, cancel = context.WithTimeout(ctx, r.options.pushTimeout)
+		defer cancel()
+	}
+
+	nextVersion, err := r.strategy.NextVersion(r.store, spaceID)
+	if err != nil {
+		return nil, err
+	}
+	lastMutationID, known, err := r.store.GetLastMutationID(pr.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if !known && len(pr.Mutations) > 0 && pr.Mutations[0].ID > 1 {
+		return nil, ErrClientStateNotFound
+	}
+
+	quota, err := r.newPushQuota(spaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := r.traceTransaction(ctx, r.store.Transaction(spaceID, pr.ClientID, nextVersion))
+	logger := r.logger(ctx).With("spaceID", spaceID, "clientID", pr.ClientID)
+	var records []MutationRecord
+
+	for _, mut := range pr.Mutations {
+		expectedMutationID := lastMutationID + 1
+		if mut.ID < expectedMutationID {
+			logger.DebugContext(ctx, "skipping processed mutation", "mutationID", mut.ID, "mutation", mut.Name)
+			r.options.metrics.ObserveMutation(r.mutatorLabel(mut.Name), MutationSkipped)
+			continue
+		}
+
+		if mut.ID > expectedMutationID {
+			logger.WarnContext(ctx, "mutation from the future, aborting", "mutationID", mut.ID, "expectedMutationID", expectedMutationID)
+			break
+		}
+
+		// Once the push has made progress, mutations past the deadline are
+		// left for the next push. The first mutation is always run, so one
+		// which alone outlasts the deadline is handled by the failure policy
+		// rather than deferred forever.
+		progressed := len(records) > 0
+		if mctx.Err() != nil && progressed {
+			logger.WarnContext(ctx, "push deadline exceeded, deferring remaining mutations", "mutationID", mut.ID)
+			break
+		}
+
+		// A mutation cut short by the push deadline after others were
+		// processed is rolled back and left for the next push, rather than
+		// handled by the failure policy.
+		sp, deferrable := savepointerOf(tx)
+		deferrable = deferrable && progressed && r.options.pushTimeout > 0
+		var mark Savepoint
+		if deferrable {
+			if mark, err = sp.Savepoint(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		skipped, err := r.applyMutation(mctx, tx, quota, mut)
+		if err != nil && deferrable && mctx.Err() != nil {
+			if rerr := sp.RollbackTo(ctx, mark); rerr != nil {
+				return nil, errors.Join(err, rerr)
+			}
+			logger.WarnContext(ctx, "push deadline exceeded, deferring remaining mutations", "mutationID", mut.ID)
+			break
+		}
+		mlogger := logger.With("mutationID", mut.ID, "mutation", mut.Name, "duration", time.Since(start))
+		rec := newMutationRecord(spaceID, pr, mut, err)
+		switch {
+		case errors.Is(err, ErrMutatorNotFound):
+			mlogger.WarnContext(ctx, "skipping unknown mutation")
+			r.options.metrics.ObserveMutation(r.mutatorLabel(mut.Name), MutationSkipped)
+		case skipped:
+			mlogger.WarnContext(ctx, "skipping failed mutation", errorAttrs(err)...)
+			r.options.metrics.ObserveMutation(r.mutatorLabel(mut.Name), MutationFailed)
+		case err != nil:
+			// The push is rolled back, so only the failure is recorded.
+			mlogger.ErrorContext(ctx, "mutation failed", errorAttrs(err)...)
+			r.options.metrics.ObserveMutation(r.mutatorLabel(mut.Name), MutationFailed)
+			return []MutationRecord{rec}, err
+		default:
+			mlogger.DebugContext(ctx, "mutation applied")
+			r.options.metrics.ObserveMutation(mut.Name, MutationApplied)
+		}
+		records = append(records, rec)
+
+		lastMutationID = expectedMutationID
+	}
+
+	err = r.commit(ctx, ChangeSet[T]{
+		SpaceID:        spaceID,
+		ClientID:       pr.ClientID,
+		Version:        nextVersion,
+		LastMutationID: lastMutationID,
+		Entries:        tx.Changes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		records[i].Version = nextVersion
+	}
+	r.options.poker.Poke(spaceID)
+	return records, nil
+}
+
+// commit checks cs against the space quota, writes it to the store and runs
+// the AfterCommit hooks. It is called with r.mu held, so hooks see commits
+// in order.
+func (r *Replicache[T]) commit(ctx context.Context, cs ChangeSet[T]) error {
+	_, span := r.options.tracer.Start(ctx, "replicache.commit")
+	span.SetAttribute("replicache.entries", len(cs.Entries))
+	usage, err := r.checkQuota(cs)
+	if err == nil {
+		err = r.store.Commit(cs)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	if usage != nil {
+		usage.apply()
+	}
+
+	r.runAfterCommit(ctx, cs)
+	return nil
+}
+
+func newMutationRecord(spaceID string, pr *PushRequest, mut Mutation, err error) MutationRecord {
+	rec := MutationRecord{
+		SpaceID:    spaceID,
+		ClientID:   pr.ClientID,
+		ProfileID:  pr.ProfileID,
+		MutationID: mut.ID,
+		Name:       mut.Name,
+		Args:       mut.Args,
+		Timestamp:  time.Now(),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// logMutations appends records to the mutation log, if any. Failures are
+// logged rather than returned, as the mutations have already been applied.
+func (r *Replicache[T]) logMutations(ctx context.Context, records ...MutationRecord) {
+	if r.options.mutationLog == nil || len(records) == 0 {
+		return
+	}
+	if err := r.options.mutationLog.Append(ctx, records...); err != nil {
+		r.logger(ctx).ErrorContext(ctx, "appending to mutation log", "error", err)
+	}
+}
+
+// Transact runs fn in a transaction on spaceID outside of any push, for
+// server-side writes. Its changes are committed at the next version of the
+// space, and the clients of the space are poked.
+func (r *Replicache[T]) Transact(ctx context.Context, spaceID string, fn func(tx ReadWriteTransaction[T]) error) error {
+	if r.store == nil {
+		return ErrNoStore
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nextVersion, err := r.strategy.NextVersion(r.store, spaceID)
+	if err != nil {
+		return err
+	}
+
+	tx := r.traceTransaction(ctx, r.store.Transaction(spaceID, "", nextVersion))
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	changes := tx.Changes()
+	if len(changes) == 0 {
+		return nil
+	}
+
+	err = r.commit(ctx, ChangeSet[T]{
+		SpaceID: spaceID,
+		Version: nextVersion,
+		Entries: changes,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.options.poker.Poke(spaceID)
+	return nil
+}
+
+// Pull returns the changes to spaceID since the cookie in pr, as computed by
+// the sync strategy. It can be passed to HandlePull.
+//
+// A client unknown to the store which claims to have pushed mutations has
+// lost its server state, and ErrClientStateNotFound is returned.
+func (r *Replicache[T]) Pull(ctx context.Context, pr *PullRequest, spaceID string) (PullResponse[T], error) {
+	if r.store == nil {
+		return PullResponse[T]{}, ErrNoStore
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lastMutationID, known, err := r.store.GetLastMutationID(pr.ClientID)
+	if err != nil {
+		return PullResponse[T]{}, err
+	}
+	if !known && pr.LastMutationID > 0 {
+		return PullResponse[T]{}, ErrClientStateNotFound
+	}
+
+	_, span := r.options.tracer.Start(ctx, "replicache.changes")
+	cookie, patch, err := r.strategy.Pull(ctx, r.store, pr, spaceID)
+	span.SetAttribute("replicache.entries", len(patch))
+	endSpan(span, err)
+	if err != nil {
+		return PullResponse[T]{}, err
+	}
+
+	resp := PullResponse[T]{
+		LastMutationID: lastMutationID,
+		Cookie:         cookie,
+		Patch:          patch,
+	}
+	if err := r.runOnPull(ctx, pr, spaceID, &resp); err != nil {
+		return PullResponse[T]{}, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,408 @@
+package replicache_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	"github.com/stretchr/testify/suite"
+)
+
+// fixture is a Replicache on a memory store, mounted on a ServeMux, with
+// "put" and "del" mutators. It is shared by the suites of this package.
+type fixture struct {
+	suite.Suite
+	rep   *replicache.Replicache[string]
+	store *memory.MemoryBackend[string]
+	mux   *http.ServeMux
+}
+
+type SyncSuite struct {
+	fixture
+}
+
+func TestSyncSuite(t *testing.T) {
+	suite.Run(t, new(SyncSuite))
+}
+
+func (s *SyncSuite) SetupTest() {
+	s.setup()
+}
+
+// setup replaces the fixture with one built with options.
+func (f *fixture) setup(options ...replicache.Option) {
+	f.rep = replicache.New[string](options...)
+	f.store = memory.New[string]()
+	f.rep.SetStore(f.store)
+	f.rep.Register("put", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		var args struct{ Key, Value string }
+		if err := json.Unmarshal(m.Args, &args); err != nil {
+			return err
+		}
+		return tx.Put(args.Key, &args.Value)
+	})
+	f.rep.Register("del", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		var key string
+		if err := json.Unmarshal(m.Args, &key); err != nil {
+			return err
+		}
+		return tx.Del(key)
+	})
+
+	f.mux = http.NewServeMux()
+	f.rep.Mount(f.mux)
+}
+
+func (f *fixture) post(path string, body any) *httptest.ResponseRecorder {
+	return f.postAs("", path, body)
+}
+
+// postAs posts body with token as its Authorization header.
+func (f *fixture) postAs(token string, path string, body any) *httptest.ResponseRecorder {
+	b, err := json.Marshal(body)
+	f.Require().NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(replicache.ReplicacheRequestIDHeader, "1")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	w := httptest.NewRecorder()
+	f.mux.ServeHTTP(w, req)
+	return w
+}
+
+func (f *fixture) pull(path string, cookie uint64) replicache.PullResponse[string] {
+	w := f.post(path, replicache.PullRequest{ClientID: "client-1", Cookie: cookie})
+	f.Require().Equal(http.StatusOK, w.Code)
+
+	var resp replicache.PullResponse[string]
+	f.Require().NoError(json.NewDecoder(w.Body).Decode(&resp))
+	return resp
+}
+
+func (f *fixture) push(spaceID string, mutations ...replicache.Mutation) {
+	w := f.post(replicache.DefaultPushEndpoint+"/"+spaceID, replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: mutations,
+	})
+	f.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+}
+
+func (s *SyncSuite) TestPushPullWithPathSpace() {
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID: "client-1",
+		Mutations: []replicache.Mutation{
+			{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"hello"}`)},
+		},
+	})
+	s.Equal(http.StatusOK, w.Code)
+
+	resp := s.pull(replicache.DefaultPullEndpoint+"/space-1", 0)
+	s.Equal(uint64(1), resp.LastMutationID)
+	s.Equal(uint64(1), resp.Cookie)
+	s.Require().Len(resp.Patch, 2)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+	s.Equal("todo/1", *resp.Patch[1].Key)
+	s.Equal("hello", *resp.Patch[1].Value)
+
+	other := s.pull(replicache.DefaultPullEndpoint+"?spaceID=space-2", 0)
+	s.Len(other.Patch, 1)
+}
+
+func (s *SyncSuite) TestPushPokesSpace() {
+	broker := replicache.NewPokeBroker()
+	s.setup(replicache.WithPoker(broker))
+
+	pokes, cancel := broker.Subscribe("space-1")
+	defer cancel()
+
+	s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{ClientID: "client-1"})
+	s.Len(pokes, 1)
+}
+
+func (s *SyncSuite) TestCORSPreflight() {
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, replicache.DefaultPushEndpoint, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// No origin is allowed by default.
+	w := preflight("https://example.com")
+	s.Empty(w.Header().Get("Access-Control-Allow-Origin"))
+	s.Empty(w.Header().Get("Access-Control-Allow-Credentials"))
+
+	s.setup(replicache.WithAllowedOrigin(replicache.AllowOrigins("https://example.com")))
+	w = preflight("https://example.com")
+	s.Equal(http.StatusNoContent, w.Code)
+	s.Equal("https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	s.Contains(w.Header().Get("Access-Control-Allow-Headers"), replicache.ReplicacheRequestIDHeader)
+
+	w = preflight("https://evil.example")
+	s.Empty(w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func (s *SyncSuite) TestPullBelowCookieFloorResets() {
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+	)
+	s.push("space-1",
+		replicache.Mutation{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/2","Value":"two"}`)},
+	)
+	s.push("space-1",
+		replicache.Mutation{ID: 3, Name: "del", Args: json.RawMessage(`"todo/1"`)},
+	)
+
+	resp := s.pull(replicache.DefaultPullEndpoint+"/space-1", 2)
+	s.Require().Len(resp.Patch, 1)
+	s.Equal(replicache.PatchDel, resp.Patch[0].Op)
+
+	purged, err := s.store.Compact(0)
+	s.Require().NoError(err)
+	s.Equal(1, purged)
+	minCookie, err := s.store.GetMinCookie("space-1")
+	s.Require().NoError(err)
+	s.Equal(uint64(3), minCookie)
+
+	resp = s.pull(replicache.DefaultPullEndpoint+"/space-1", 2)
+	s.Equal(uint64(3), resp.Cookie)
+	s.Require().Len(resp.Patch, 2)
+	s.Equal(replicache.PatchClear, resp.Patch[0].Op)
+	s.Equal(replicache.PatchPut, resp.Patch[1].Op)
+	s.Equal("todo/2", *resp.Patch[1].Key)
+
+	resp = s.pull(replicache.DefaultPullEndpoint+"/space-1", 3)
+	s.Empty(resp.Patch)
+}
+
+func (s *SyncSuite) TestPrunedClientIsReset() {
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+	)
+	pruned, err := s.store.PruneClients(0)
+	s.Require().NoError(err)
+	s.Equal(1, pruned)
+
+	w := s.post(replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "client-1", Cookie: 1, LastMutationID: 1})
+	s.Equal(http.StatusOK, w.Code)
+	s.JSONEq(`{"error":"ClientStateNotFound"}`, w.Body.String())
+
+	w = s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID:  "client-1",
+		Mutations: []replicache.Mutation{{ID: 2, Name: "put", Args: json.RawMessage(`{"Key":"todo/2","Value":"two"}`)}},
+	})
+	s.Equal(http.StatusOK, w.Code)
+	s.JSONEq(`{"error":"ClientStateNotFound"}`, w.Body.String())
+
+	// A new client starts from scratch.
+	w = s.post(replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "client-2"})
+	s.Equal(http.StatusOK, w.Code)
+}
+
+func (s *SyncSuite) TestPushRecordsMutations() {
+	mutations := memory.NewMutationLog()
+	s.setup(replicache.WithMutationLog(mutations))
+
+	w := s.post(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID:  "client-1",
+		ProfileID: "profile-1",
+		Mutations: []replicache.Mutation{
+			{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+			{ID: 2, Name: "missing"},
+		},
+	})
+	s.Require().Equal(http.StatusOK, w.Code)
+
+	records, err := replicache.MutationsBySpace(context.Background(), mutations, "space-1")
+	s.Require().NoError(err)
+	s.Require().Len(records, 2)
+	s.Equal("client-1", records[0].ClientID)
+	s.Equal("profile-1", records[0].ProfileID)
+	s.Equal(uint64(1), records[0].MutationID)
+	s.Equal(uint64(1), records[0].Version)
+	s.Empty(records[0].Error)
+	s.Equal(replicache.ErrMutatorNotFound.Error(), records[1].Error)
+}
+
+func (s *SyncSuite) TestRecordReplay() {
+	capture := new(bytes.Buffer)
+	recorded := replicache.Record(capture)(s.mux)
+	serve := func(path string, body any) {
+		b, err := json.Marshal(body)
+		s.Require().NoError(err)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(replicache.ReplicacheRequestIDHeader, "1")
+		req.Header.Set("Cookie", "session=secret")
+		recorded.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(replicache.DefaultPushEndpoint+"/space-1", replicache.PushRequest{
+		ClientID: "client-1",
+		Mutations: []replicache.Mutation{
+			{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"hello"}`)},
+		},
+	})
+	serve(replicache.DefaultPullEndpoint+"/space-1", replicache.PullRequest{ClientID: "client-1"})
+	s.Equal(2, bytes.Count(capture.Bytes(), []byte("\n")))
+	s.NotContains(capture.String(), "secret")
+	s.Contains(capture.String(), http.CanonicalHeaderKey(replicache.ReplicacheRequestIDHeader))
+
+	s.setup()
+	diffs, err := replicache.Replay(s.mux, bytes.NewReader(capture.Bytes()))
+	s.Require().NoError(err)
+	s.Empty(diffs)
+
+	// A store which already holds data diverges on the pull.
+	s.setup()
+	s.store.PutEntry("space-1", "todo/2", "stale", 1)
+	diffs, err = replicache.Replay(s.mux, bytes.NewReader(capture.Bytes()))
+	s.Require().NoError(err)
+	s.Require().Len(diffs, 1)
+	s.Equal(2, diffs[0].Line)
+	s.Contains(string(diffs[0].Response), "stale")
+}
+
+type recordingMetrics struct {
+	pushes, pulls, resets int
+	mutations             map[string]replicache.MutationResult
+	authFailures          []replicache.Operation
+}
+
+func (m *recordingMetrics) ObservePush(time.Duration, error) { m.pushes++ }
+
+func (m *recordingMetrics) ObserveMutation(name string, result replicache.MutationResult) {
+	m.mutations[name] = result
+}
+
+func (m *recordingMetrics) ObservePull(d time.Duration, patchSize int, reset bool, err error) {
+	m.pulls++
+	if reset {
+		m.resets++
+	}
+}
+
+func (m *recordingMetrics) AuthFailed(op replicache.Operation) {
+	m.authFailures = append(m.authFailures, op)
+}
+
+func (s *SyncSuite) TestMetrics() {
+	metrics := &recordingMetrics{mutations: make(map[string]replicache.MutationResult)}
+	s.setup(
+		replicache.WithMetrics(metrics),
+		replicache.WithAuth(func(ctx context.Context, token string) (any, bool) { return nil, token == "" }),
+	)
+
+	s.push("space-1",
+		replicache.Mutation{ID: 1, Name: "put", Args: json.RawMessage(`{"Key":"todo/1","Value":"one"}`)},
+		replicache.Mutation{ID: 2, Name: "whatever"},
+	)
+	s.pull(replicache.DefaultPullEndpoint+"/space-1", 0)
+
+	s.postAs("bad", replicache.DefaultPullEndpoint, replicache.PullRequest{})
+
+	s.Equal(1, metrics.pushes)
+	s.Equal(1, metrics.pulls)
+	s.Equal(1, metrics.resets)
+	s.Equal(map[string]replicache.MutationResult{
+		"put":     replicache.MutationApplied,
+		"unknown": replicache.MutationSkipped,
+	}, metrics.mutations)
+	s.Equal([]replicache.Operation{replicache.OperationPull}, metrics.authFailures)
+}
+
+func (s *SyncSuite) TestLogger() {
+	logs := new(bytes.Buffer)
+	s.setup(replicache.WithLogger(slog.New(slog.NewJSONHandler(logs, nil))))
+
+	s.push("space-1", replicache.Mutation{ID: 1, Name: "missing"})
+
+	var record map[string]any
+	s.Require().NoError(json.Unmarshal(logs.Bytes(), &record))
+	s.Equal("WARN", record["level"])
+	s.Equal("skipping unknown mutation", record["msg"])
+	s.Equal("1", record["requestID"])
+	s.Equal("space-1", record["spaceID"])
+	s.Equal("client-1", record["clientID"])
+	s.Equal(float64(1), record["mutationID"])
+	s.Equal("missing", record["mutation"])
+	s.Contains(record, "duration")
+}
+
+func (s *SyncSuite) TestPokeAuth() {
+	s.setup(
+		replicache.WithAuth(func(ctx context.Context, token string) (any, bool) {
+			return token, token == "alice" || token == "bob"
+		}),
+		replicache.WithSpaceAuthorizer(func(ctx context.Context, principal any, spaceID string, op replicache.Operation) error {
+			if principal != "alice" || op != replicache.OperationPull {
+				return errors.New("forbidden")
+			}
+			return nil
+		}),
+		replicache.WithPokeQueryToken(),
+	)
+	s.rep.RevokeClient("client-2")
+
+	poke := func(query string) int {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodGet, replicache.DefaultPokeEndpoint+"/space-1?"+query, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	s.Equal(http.StatusUnauthorized, poke(""))
+	s.Equal(http.StatusUnauthorized, poke("auth=mallory"))
+	s.Equal(http.StatusForbidden, poke("auth=bob&clientID=client-1"))
+	s.Equal(http.StatusBadRequest, poke("auth=alice"))
+	s.Equal(http.StatusForbidden, poke("auth=alice&clientID=client-2"))
+	s.Equal(http.StatusOK, poke("auth=alice&clientID=client-1"))
+
+	// Tokens are only taken from the query when WithPokeQueryToken is set.
+	s.setup(replicache.WithAuth(func(ctx context.Context, token string) (any, bool) {
+		return token, token == "alice"
+	}))
+	s.Equal(http.StatusUnauthorized, poke("auth=alice&clientID=client-1"))
+}
+
+func (s *SyncSuite) TestPokeRevokeClosesStream() {
+	s.setup()
+	server := httptest.NewServer(s.mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + replicache.DefaultPokeEndpoint + "/space-1?clientID=client-1")
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	s.rep.RevokeClient("client-1")
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(resp.Body)
+		closed <- err
+	}()
+	select {
+	case err := <-closed:
+		s.NoError(err)
+	case <-time.After(time.Second):
+		s.Fail("stream of revoked client still open")
+	}
+}
@@ -0,0 +1,100 @@
+package replicache
+
+import "context"
+
+type (
+	// Tracer starts spans around the stages of push and pull handling:
+	// "replicache.push" and "replicache.pull" for each request,
+	// "replicache.auth" and "replicache.authorize" for the AuthFn and
+	// SpaceAuthorizer, "replicache.mutator" for each mutation,
+	// "replicache.tx.get", "replicache.tx.put" and "replicache.tx.del" for
+	// transaction calls, and "replicache.commit" and "replicache.changes"
+	// for store writes and reads.
+	Tracer interface {
+		Start(ctx context.Context, name string) (context.Context, Span)
+	}
+
+	Span interface {
+		SetAttribute(key string, value any)
+		RecordError(err error)
+		End()
+	}
+
+	nopTracer struct{}
+	nopSpan   struct{}
+
+	// tracedTransaction starts a span for each call. ctx is the context of
+	// the mutator currently using the transaction.
+	tracedTransaction[T any] struct {
+		ReadWriteTransaction[T]
+		ctx    context.Context
+		tracer Tracer
+	}
+)
+
+// WithTracer sets the Tracer spans are started with. Spans are discarded by
+// default.
+func WithTracer(t Tracer) Option {
+	return func(o *Options) {
+		o.tracer = t
+	}
+}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SetAttribute(string, any) {}
+func (nopSpan) RecordError(error)        {}
+func (nopSpan) End()                     {}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// traceTransaction wraps tx so its calls are traced, unless tracing is
+// disabled.
+func (r *Replicache[T]) traceTransaction(ctx context.Context, tx ReadWriteTransaction[T]) ReadWriteTransaction[T] {
+	if _, ok := r.options.tracer.(nopTracer); ok {
+		return tx
+	}
+	return &tracedTransaction[T]{ReadWriteTransaction: tx, ctx: ctx, tracer: r.options.tracer}
+}
+
+// withContext sets the context later calls on tx are traced under.
+func withContext[T any](tx ReadWriteTransaction[T], ctx context.Context) {
+	if q, ok := tx.(*quotaTransaction[T]); ok {
+		tx = q.ReadWriteTransaction
+	}
+	if traced, ok := tx.(*tracedTransaction[T]); ok {
+		traced.ctx = ctx
+	}
+}
+
+func (t *tracedTransaction[T]) Get(key string) (*T, error) {
+	_, span := t.tracer.Start(t.ctx, "replicache.tx.get")
+	span.SetAttribute("replicache.key", key)
+	value, err := t.ReadWriteTransaction.Get(key)
+	endSpan(span, err)
+	return value, err
+}
+
+func (t *tracedTransaction[T]) Put(key string, value *T) error {
+	_, span := t.tracer.Start(t.ctx, "replicache.tx.put")
+	span.SetAttribute("replicache.key", key)
+	err := t.ReadWriteTransaction.Put(key, value)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedTransaction[T]) Del(key string) error {
+	_, span := t.tracer.Start(t.ctx, "replicache.tx.del")
+	span.SetAttribute("replicache.key", key)
+	err := t.ReadWriteTransaction.Del(key)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,65 @@
+// Package tracing adapts OpenTelemetry to replicache.Tracer.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/airheartdev/replicache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	otelTracer struct {
+		tracer trace.Tracer
+	}
+
+	otelSpan struct {
+		span trace.Span
+	}
+)
+
+// OpenTelemetry returns a replicache.Tracer which starts its spans with
+// tracer, e.g. otel.Tracer("replicache").
+func OpenTelemetry(tracer trace.Tracer) replicache.Tracer {
+	return otelTracer{tracer: tracer}
+}
+
+func (t otelTracer) Start(ctx context.Context, name string) (context.Context, replicache.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, otelSpan{span: span}
+}
+
+func (s otelSpan) SetAttribute(key string, value any) {
+	s.span.SetAttributes(attributeOf(key, value))
+}
+
+func (s otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
+
+func attributeOf(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case uint64:
+		return attribute.Int64(key, int64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/airheartdev/replicache"
+	"github.com/airheartdev/replicache/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOpenTelemetry(t *testing.T) {
+	a := assert.New(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	rep := replicache.New[string](replicache.WithTracer(OpenTelemetry(provider.Tracer("replicache"))))
+	rep.SetStore(memory.New[string]())
+	rep.Register("put", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		v := "one"
+		return tx.Put("todo/1", &v)
+	})
+	rep.Register("fail", func(ctx context.Context, tx replicache.ReadWriteTransaction[string], m replicache.Mutation) error {
+		return errors.New("boom")
+	})
+	mux := http.NewServeMux()
+	rep.Mount(mux)
+
+	push := func(mutations ...replicache.Mutation) {
+		body, err := json.Marshal(replicache.PushRequest{ClientID: "client-1", Mutations: mutations})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, replicache.DefaultPushEndpoint+"/space-1", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(replicache.ReplicacheRequestIDHeader, "1")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	push(replicache.Mutation{ID: 1, Name: "put"})
+
+	spans := exporter.GetSpans()
+	names := make([]string, 0, len(spans))
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		names = append(names, span.Name)
+		byName[span.Name] = span
+	}
+	a.Equal([]string{"replicache.auth", "replicache.tx.put", "replicache.mutator", "replicache.commit", "replicache.push"}, names)
+
+	root := byName["replicache.push"].SpanContext
+	mutator := byName["replicache.mutator"]
+	a.Equal(root.SpanID(), mutator.Parent.SpanID())
+	a.Equal(mutator.SpanContext.SpanID(), byName["replicache.tx.put"].Parent.SpanID())
+	a.Equal(root.SpanID(), byName["replicache.commit"].Parent.SpanID())
+	a.Contains(mutator.Attributes, attributeOf("replicache.mutator", "put"))
+
+	exporter.Reset()
+	push(replicache.Mutation{ID: 2, Name: "fail"})
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "replicache.mutator" || span.Name == "replicache.push" {
+			a.Equal(codes.Error, span.Status.Code, span.Name)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package replicache
 
+import (
+	"context"
+	"time"
+)
+
 type (
 	ReadWriteTransaction[T any] interface {
 		ReadTransaction[T]
@@ -10,8 +15,25 @@ type (
 		Put(key string, value *T) error
 		Del(key string) error
 		Flush() error
+		// Changes returns the buffered writes as entries at the version of
+		// the transaction. Deletes have Deleted set.
+		Changes() []Entry[T]
+	}
+
+	// Savepointer is implemented by transactions which can undo the writes
+	// of a single mutation, as failure policies other than AbortOnFailure
+	// need.
+	Savepointer interface {
+		// Savepoint marks the writes made so far.
+		Savepoint(ctx context.Context) (Savepoint, error)
+		// RollbackTo undoes the writes made since sp was taken. sp remains
+		// valid, while savepoints taken after it are released.
+		RollbackTo(ctx context.Context, sp Savepoint) error
 	}
 
+	// Savepoint identifies a point in a transaction to roll back to.
+	Savepoint int
+
 	ReadTransaction[T any] interface {
 		Get(key string) (*T, error)
 		Has(key string) bool
@@ -23,11 +45,23 @@ type (
 		Dirty bool
 	}
 
-	Entry struct {
-		Key     string
-		Value   any
-		Deleted bool
-		SpaceID string
-		Version uint64
+	Entry[T any] struct {
+		SpaceID        string
+		Key            string
+		Value          T
+		Deleted        bool
+		Version        uint64
+		LastModifiedAt time.Time
+	}
+
+	// ChangeSet is everything written by a push: the entries changed in the
+	// space, the client's new last mutation ID and the space's new version.
+	// Stores commit a ChangeSet atomically.
+	ChangeSet[T any] struct {
+		SpaceID        string
+		ClientID       string
+		Version        uint64
+		LastMutationID uint64
+		Entries        []Entry[T]
 	}
 )